@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/umee-network/fonzie/chain"
+)
+
+// pausedErr tells the requester a chain's faucet is too low on funds to take requests
+type pausedErr struct {
+	prefix string
+}
+
+func (e pausedErr) Error() string {
+	return fmt.Sprintf("the %s faucet is running dry and paused until it is refilled, please try again later", e.prefix)
+}
+
+// balanceMonitor keeps the last balance level of every chain with alerts
+type balanceMonitor struct {
+	mu     sync.RWMutex
+	levels map[string]chain.BalanceLevel
+}
+
+func newBalanceMonitor() *balanceMonitor {
+	return &balanceMonitor{levels: map[string]chain.BalanceLevel{}}
+}
+
+// paused tells if requests for the chain are refused because its balance is critical
+func (m *balanceMonitor) paused(prefix string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.levels[prefix] == chain.BalanceCritical
+}
+
+// set records the level of a chain and returns the previous one
+func (m *balanceMonitor) set(prefix string, level chain.BalanceLevel) chain.BalanceLevel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.levels[prefix]
+	m.levels[prefix] = level
+	return previous
+}
+
+// watchBalance checks the faucet balance of c every alert interval, alerting the admins whenever
+// it crosses a threshold, until ctx is done
+func (fh FaucetHandler) watchBalance(ctx context.Context, c *chain.Chain, admin adminChannel) {
+	interval, err := c.AlertInterval()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		level, balance, err := c.BalanceLevel(ctx)
+		if err != nil {
+			// keep the last level, a failing node doesn't tell anything about the balance
+			log.Errorf("failed to check the %s faucet balance: %v", c.Prefix, err)
+		} else if previous := fh.balances.set(c.Prefix, level); previous != level {
+			admin.announce(balanceNotice(c, level, balance))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func balanceNotice(c *chain.Chain, level chain.BalanceLevel, balance cosmostypes.Coins) string {
+	coins := ChainFaucet{chain: c}.formatCoins(balance)
+	switch level {
+	case chain.BalanceCritical:
+		return fmt.Sprintf("🚨 The %s faucet balance is critical, requests are paused\nCurrent balance: %s", c.Prefix, coins)
+	case chain.BalanceWarning:
+		return fmt.Sprintf("⚠️ The %s faucet balance is running low\nCurrent balance: %s", c.Prefix, coins)
+	default:
+		return fmt.Sprintf("✅ The %s faucet balance is back above its thresholds\nCurrent balance: %s", c.Prefix, coins)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/cosmos/btcutil/bech32"
+
+	"github.com/umee-network/fonzie/chain"
+)
+
+func TestBalanceMonitorTransitions(t *testing.T) {
+	m := newBalanceMonitor()
+	c := &chain.Chain{Prefix: "umee"}
+	// the faucet runs dry, then is refilled
+	steps := []struct {
+		level  chain.BalanceLevel
+		notice string
+		paused bool
+	}{
+		{chain.BalanceOK, "", false},
+		{chain.BalanceWarning, "⚠️ The umee faucet balance is running low", false},
+		{chain.BalanceWarning, "", false},
+		{chain.BalanceCritical, "🚨 The umee faucet balance is critical, requests are paused", true},
+		{chain.BalanceCritical, "", true},
+		{chain.BalanceWarning, "⚠️ The umee faucet balance is running low", false},
+		{chain.BalanceOK, "✅ The umee faucet balance is back above its thresholds", false},
+	}
+	for i, s := range steps {
+		// watchBalance announces a level only when it changed
+		var notice string
+		if previous := m.set("umee", s.level); previous != s.level {
+			notice = balanceNotice(c, s.level, nil)
+		}
+		if !strings.HasPrefix(notice, s.notice) || (notice == "") != (s.notice == "") {
+			t.Errorf("step %d: announced %q, want %q", i, notice, s.notice)
+		}
+		if got := m.paused("umee"); got != s.paused {
+			t.Errorf("step %d: paused %v, want %v", i, got, s.paused)
+		}
+	}
+	if m.paused("atom") {
+		t.Error("paused a chain without alerts")
+	}
+}
+
+// pausedFaucet returns a handler whose umee chain is paused, and an address on it
+func pausedFaucet(t *testing.T) (FaucetHandler, string) {
+	fh := FaucetHandler{
+		faucets:  map[string]ChainFaucet{"umee": {chain: &chain.Chain{Prefix: "umee"}}},
+		balances: newBalanceMonitor(),
+	}
+	fh.balances.set("umee", chain.BalanceCritical)
+	addr, err := bech32.EncodeFromBase256("umee", make([]byte, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fh, addr
+}
+
+func TestPausedChainHTTPRequest(t *testing.T) {
+	fh, addr := pausedFaucet(t)
+	h, err := NewHTTPHandler(fh, "ci:secret", "", NoopCaptcha{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := postRequest(t, h, "secret", `{"address": "`+addr+`"}`); got != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503", got)
+	}
+}
+
+func TestPausedChainChatRequest(t *testing.T) {
+	fh, addr := pausedFaucet(t)
+	var l chatLog
+	c := chatCmd{client: &l, msg: ChatMessage{ID: "1", UserID: "alice"}, requester: Requester{ID: "slack:alice"}}
+	if fh.requestChat(c, addr) {
+		t.Error("queued a request for a paused chain")
+	}
+	if len(l) != 2 || l[0] != "add ❌" || !strings.Contains(l[1], "paused until it is refilled") {
+		t.Errorf("answered %q", l)
+	}
+}
+
+func TestPausedChainDiscordRequest(t *testing.T) {
+	api := newFakeAPI(t, func(c apiCall) (int, interface{}) {
+		return http.StatusOK, map[string]string{"id": "2"}
+	})
+	endpoint := discordgo.EndpointChannels
+	discordgo.EndpointChannels = api.URL + "/channels/"
+	t.Cleanup(func() { discordgo.EndpointChannels = endpoint })
+	session, err := discordgo.New("Bot TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fh, addr := pausedFaucet(t)
+	c := discordCmd{session: session, msg: &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "1",
+		ChannelID: "C1",
+		Content:   "!request " + addr,
+		Author:    &discordgo.User{ID: "42", Username: "alice"},
+	}}}
+	if fh.request(c, addr, "") {
+		t.Error("queued a request for a paused chain")
+	}
+	sent := api.called(http.MethodPost, "/channels/C1/messages")
+	if len(sent) != 1 || !strings.Contains(sent[0].Body["content"].(string), "paused until it is refilled") {
+		t.Errorf("replied %v", sent)
+	}
+}
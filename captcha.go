@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// CaptchaVerifier checks CAPTCHA tokens solved on the web page before a request is queued
+type CaptchaVerifier interface {
+	// Verify returns an error unless token is a valid solution from remoteIP
+	Verify(ctx context.Context, token string, remoteIP string) error
+	// Widget describes how the web page renders the CAPTCHA, nil when there is none
+	Widget() *CaptchaWidget
+}
+
+// CaptchaWidget is what the web page needs to render a provider's CAPTCHA
+type CaptchaWidget struct {
+	Script  string
+	Class   string
+	SiteKey string
+	// ResponseField is the form field the provider's script fills with the token
+	ResponseField string
+}
+
+// NoopCaptcha accepts every request, for tests and deployments without a CAPTCHA
+type NoopCaptcha struct{}
+
+func (NoopCaptcha) Verify(ctx context.Context, token string, remoteIP string) error {
+	return nil
+}
+
+func (NoopCaptcha) Widget() *CaptchaWidget {
+	return nil
+}
+
+// siteVerifyCaptcha implements the `siteverify` API shared by hCaptcha, Turnstile and reCAPTCHA
+type siteVerifyCaptcha struct {
+	verifyURL string
+	secret    string
+	widget    CaptchaWidget
+	client    *resty.Client
+}
+
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func (c *siteVerifyCaptcha) Verify(ctx context.Context, token string, remoteIP string) error {
+	if token == "" {
+		return fmt.Errorf("please solve the captcha")
+	}
+	var result siteVerifyResponse
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetFormData(map[string]string{
+			"secret":   c.secret,
+			"response": token,
+			"remoteip": remoteIP,
+		}).
+		SetResult(&result).
+		Post(c.verifyURL)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("could not verify captcha; http error code received %d", resp.StatusCode())
+	}
+	if !result.Success {
+		return fmt.Errorf("captcha verification failed: %s", strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
+
+func (c *siteVerifyCaptcha) Widget() *CaptchaWidget {
+	return &c.widget
+}
+
+// NewCaptchaVerifier creates the verifier for provider, which is one of hcaptcha, turnstile,
+// recaptcha, or empty for no CAPTCHA at all
+func NewCaptchaVerifier(provider string, siteKey string, secret string) (CaptchaVerifier, error) {
+	c := &siteVerifyCaptcha{
+		secret: secret,
+		client: resty.New().SetTimeout(5 * time.Second),
+	}
+	switch provider {
+	case "":
+		return NoopCaptcha{}, nil
+	case "hcaptcha":
+		c.verifyURL = "https://api.hcaptcha.com/siteverify"
+		c.widget = CaptchaWidget{Script: "https://js.hcaptcha.com/1/api.js", Class: "h-captcha", ResponseField: "h-captcha-response"}
+	case "turnstile":
+		c.verifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+		c.widget = CaptchaWidget{Script: "https://challenges.cloudflare.com/turnstile/v0/api.js", Class: "cf-turnstile", ResponseField: "cf-turnstile-response"}
+	case "recaptcha":
+		c.verifyURL = "https://www.google.com/recaptcha/api/siteverify"
+		c.widget = CaptchaWidget{Script: "https://www.google.com/recaptcha/api.js", Class: "g-recaptcha", ResponseField: "g-recaptcha-response"}
+	default:
+		return nil, fmt.Errorf("unknown captcha provider %q", provider)
+	}
+	if siteKey == "" || secret == "" {
+		return nil, fmt.Errorf("%s needs both CAPTCHA_SITE_KEY and CAPTCHA_SECRET", provider)
+	}
+	c.widget.SiteKey = siteKey
+	return c, nil
+}
@@ -0,0 +1,116 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+)
+
+const defaultAlertInterval = time.Minute
+
+// BalanceAlerts are the faucet balances, summed over its accounts, below which the operators are alerted.
+// Below Critical the chain stops taking requests.
+type BalanceAlerts struct {
+	Warning  string `json:"warning"`
+	Critical string `json:"critical"`
+	// Interval is how often the balances are checked, e.g. `1m`
+	Interval string `json:"interval"`
+}
+
+// BalanceLevel tells how close the faucet of a chain is to running dry
+type BalanceLevel int
+
+const (
+	BalanceOK BalanceLevel = iota
+	BalanceWarning
+	BalanceCritical
+)
+
+func (l BalanceLevel) String() string {
+	switch l {
+	case BalanceWarning:
+		return "warning"
+	case BalanceCritical:
+		return "critical"
+	default:
+		return "ok"
+	}
+}
+
+// parse returns the warning and critical thresholds and the check interval of the alerts
+func (a BalanceAlerts) parse() (cosmostypes.Coins, cosmostypes.Coins, time.Duration, error) {
+	warning, err := cosmostypes.ParseCoinsNormalized(a.Warning)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid warning threshold: %w", err)
+	}
+	critical, err := cosmostypes.ParseCoinsNormalized(a.Critical)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("invalid critical threshold: %w", err)
+	}
+	if warning.IsZero() && critical.IsZero() {
+		return nil, nil, 0, fmt.Errorf("a warning or critical threshold is required")
+	}
+	interval := defaultAlertInterval
+	if a.Interval != "" {
+		interval, err = time.ParseDuration(a.Interval)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid interval: %w", err)
+		}
+		if interval <= 0 {
+			return nil, nil, 0, fmt.Errorf("interval must be positive")
+		}
+	}
+	return warning, critical, interval, nil
+}
+
+// AlertInterval returns how often the faucet balance is checked, or 0 when the chain has no alerts
+func (chain Chain) AlertInterval() (time.Duration, error) {
+	if chain.Alerts == nil {
+		return 0, nil
+	}
+	_, _, interval, err := chain.Alerts.parse()
+	if err != nil {
+		return 0, fmt.Errorf("invalid alerts for %s: %w", chain.Prefix, err)
+	}
+	return interval, nil
+}
+
+// BalanceLevel compares what the faucet accounts hold together with the alert thresholds.
+// The balance is returned along with its level.
+func (chain Chain) BalanceLevel(ctx context.Context) (BalanceLevel, cosmostypes.Coins, error) {
+	if chain.Alerts == nil {
+		return BalanceOK, nil, nil
+	}
+	warning, critical, _, err := chain.Alerts.parse()
+	if err != nil {
+		return BalanceOK, nil, err
+	}
+	balance, err := chain.Balances(ctx)
+	if err != nil {
+		return BalanceOK, nil, err
+	}
+	return balanceLevel(balance, warning, critical), balance, nil
+}
+
+func balanceLevel(balance cosmostypes.Coins, warning cosmostypes.Coins, critical cosmostypes.Coins) BalanceLevel {
+	switch {
+	case below(balance, critical):
+		return BalanceCritical
+	case below(balance, warning):
+		return BalanceWarning
+	default:
+		return BalanceOK
+	}
+}
+
+// below tells if balance holds less than threshold of any of its denoms
+func below(balance cosmostypes.Coins, threshold cosmostypes.Coins) bool {
+	for _, coin := range threshold {
+		if balance.AmountOf(coin.Denom).LT(coin.Amount) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package chain
+
+import (
+	"testing"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+)
+
+func coins(t *testing.T, s string) cosmostypes.Coins {
+	c, err := cosmostypes.ParseCoinsNormalized(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBalanceLevel(t *testing.T) {
+	warning := coins(t, "500uumee,50uatom")
+	critical := coins(t, "100uumee")
+	// a faucet running dry, then refilled
+	cases := []struct {
+		balance string
+		want    BalanceLevel
+	}{
+		{"1000uumee,100uatom", BalanceOK},
+		{"500uumee,50uatom", BalanceOK},
+		{"499uumee,50uatom", BalanceWarning},
+		// any denom below its threshold counts
+		{"1000uumee,10uatom", BalanceWarning},
+		{"99uumee,100uatom", BalanceCritical},
+		{"", BalanceCritical},
+		{"100uumee,100uatom", BalanceWarning},
+		{"1000uumee,100uatom", BalanceOK},
+	}
+	for _, c := range cases {
+		if got := balanceLevel(coins(t, c.balance), warning, critical); got != c.want {
+			t.Errorf("balance %q is %s, want %s", c.balance, got, c.want)
+		}
+	}
+	// without a critical threshold nothing pauses the chain
+	if got := balanceLevel(nil, warning, nil); got != BalanceWarning {
+		t.Errorf("empty balance without a critical threshold is %s, want warning", got)
+	}
+}
@@ -2,10 +2,15 @@ package chain
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/cosmos/cosmos-sdk/crypto/hd"
 	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/types/query"
 	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
 	"github.com/go-resty/resty/v2"
 	log "github.com/sirupsen/logrus"
@@ -25,6 +30,22 @@ func (chains Chains) ImportMnemonic(ctx context.Context, mnemonic string) error
 	return nil
 }
 
+// ImportTreasury imports the treasury key of every chain with a top-up
+func (chains Chains) ImportTreasury(ctx context.Context, mnemonic string) error {
+	for _, info := range chains {
+		if info.TopUp == nil {
+			continue
+		}
+		if mnemonic == "" {
+			return fmt.Errorf("%s has a top_up but no treasury mnemonic is set", info.Prefix)
+		}
+		if err := info.ImportTreasury(mnemonic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (chains Chains) FindByPrefix(prefix string) *Chain {
 	for _, info := range chains {
 		if info.Prefix == prefix {
@@ -35,10 +56,108 @@ func (chains Chains) FindByPrefix(prefix string) *Chain {
 }
 
 type Chain struct {
-	Prefix   string                        `json:"prefix"`
-	RPC      string                        `json:"rpc"`
-	CoinType uint32                        `json:"coin_type"`
-	client   *customlens.CustomChainClient `json:"-"`
+	Prefix   string `json:"prefix"`
+	RPC      string `json:"rpc"`
+	CoinType uint32 `json:"coin_type"`
+	// Denom is the base denom shown with DisplayDenom and Decimals, e.g. `uumee` shown as `UMEE` with 6 decimals
+	Denom        string `json:"denom"`
+	DisplayDenom string `json:"display_denom"`
+	Decimals     uint32 `json:"decimals"`
+	// BatchInterval is how long requests are collected before they are sent, e.g. `4s`
+	BatchInterval string `json:"batch_interval"`
+	// MaxBatch is the most recipients sent in one multi-send
+	MaxBatch int `json:"max_batch"`
+	// MaxGas caps the gas of one tx, below the block gas limit of the chain
+	MaxGas uint64 `json:"max_gas"`
+	// ConfirmTimeout is how long to wait for a broadcast tx to be committed, e.g. `30s`
+	ConfirmTimeout string `json:"confirm_timeout"`
+	// Accounts is how many accounts, HD address indexes 0 to Accounts-1 of the mnemonic, sign faucet txs
+	Accounts int `json:"accounts"`
+	// TopUp refills the faucet accounts from the treasury key when they run low
+	TopUp *TopUp `json:"top_up"`
+	// Alerts warns the operators when the faucet runs low and pauses requests when it is nearly empty
+	Alerts   *BalanceAlerts                  `json:"alerts"`
+	clients  []*customlens.CustomChainClient `json:"-"`
+	signers  *signerPool                     `json:"-"`
+	treasury *customlens.CustomChainClient   `json:"-"`
+	refills  *pendingRefills                 `json:"-"`
+}
+
+// AccountBalance is what one faucet account of a chain holds
+type AccountBalance struct {
+	Address string
+	Coins   cosmostypes.Coins
+}
+
+// TxResult is a tx committed in a block, or only broadcast when it returns with ErrUnconfirmed
+type TxResult struct {
+	Hash    string
+	Height  int64
+	GasUsed int64
+	Code    uint32
+}
+
+// ErrUnconfirmed is returned for a tx which passed CheckTx but was not seen in a block in time.
+// It may still be committed later.
+var ErrUnconfirmed = errors.New("the transaction was broadcast but not confirmed in time")
+
+const (
+	defaultBatchInterval  = time.Second
+	defaultMaxBatch       = 160
+	defaultConfirmTimeout = 30 * time.Second
+)
+
+// Confirmation returns how long to wait for a broadcast tx to be committed
+func (chain Chain) Confirmation() (time.Duration, error) {
+	if chain.ConfirmTimeout == "" {
+		return defaultConfirmTimeout, nil
+	}
+	timeout, err := time.ParseDuration(chain.ConfirmTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid confirm_timeout for %s: %w", chain.Prefix, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("confirm_timeout for %s must be positive", chain.Prefix)
+	}
+	return timeout, nil
+}
+
+// Batching returns how long requests are collected and how many recipients one multi-send takes at most
+func (chain Chain) Batching() (time.Duration, int, error) {
+	interval := defaultBatchInterval
+	if chain.BatchInterval != "" {
+		var err error
+		interval, err = time.ParseDuration(chain.BatchInterval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid batch_interval for %s: %w", chain.Prefix, err)
+		}
+		if interval <= 0 {
+			return 0, 0, fmt.Errorf("batch_interval for %s must be positive", chain.Prefix)
+		}
+	}
+	maxBatch := defaultMaxBatch
+	if chain.MaxBatch < 0 {
+		return 0, 0, fmt.Errorf("max_batch for %s can't be negative", chain.Prefix)
+	} else if chain.MaxBatch > 0 {
+		maxBatch = chain.MaxBatch
+	}
+	return interval, maxBatch, nil
+}
+
+// GasLimit returns the most gas a tx may use: the lower of MaxGas and the block gas limit, 0 if neither is set
+func (chain Chain) GasLimit(ctx context.Context) (uint64, error) {
+	blockGas, err := chain.GetClient().MaxBlockGas(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return gasLimit(blockGas, chain.MaxGas), nil
+}
+
+func gasLimit(blockGas uint64, maxGas uint64) uint64 {
+	if maxGas > 0 && (blockGas == 0 || maxGas < blockGas) {
+		return maxGas
+	}
+	return blockGas
 }
 
 type TxResponse struct {
@@ -46,8 +165,9 @@ type TxResponse struct {
 	Hash   string `json:"txhash"`
 }
 
+// GetClient returns the client of the first faucet account
 func (chain *Chain) GetClient() *customlens.CustomChainClient {
-	if chain.client == nil {
+	if chain.clients == nil {
 		if chain.CoinType == 0 {
 			// default to cosmos
 			chain.CoinType = 118
@@ -56,51 +176,94 @@ func (chain *Chain) GetClient() *customlens.CustomChainClient {
 		if err != nil {
 			log.Fatalf("failed to get chain id for %s. err: %v", chain.Prefix, err)
 		}
-
-		// Build chain config
-		chainConfig := lens.ChainClientConfig{
-			Key:            "anon",
-			ChainID:        chainID,
-			RPCAddr:        chain.RPC,
-			AccountPrefix:  chain.Prefix,
-			KeyringBackend: "memory",
-			GasAdjustment:  1.5,
-			Debug:          true,
-			Timeout:        "5s",
-			OutputFormat:   "json",
-			SignModeStr:    "direct",
-			Modules:        lens.ModuleBasics,
-		}
-		chainConfig.Key = "anon"
-
-		// Creates client object to pull chain info
-		c, err := lens.NewChainClient(&chainConfig, "", os.Stdin, os.Stdout)
-		if err != nil {
-			log.Fatal(err)
+		accounts := chain.Accounts
+		if accounts < 1 {
+			accounts = 1
+		}
+		// each account gets its own client, lens signs with the key named in the client config
+		for i := 0; i < accounts; i++ {
+			chain.clients = append(chain.clients, newClient(chain, chainID))
 		}
+		chain.signers = newSignerPool(chain.clients)
+	}
+	return chain.clients[0]
+}
+
+func newClient(chain *Chain, chainID string) *customlens.CustomChainClient {
+	// Build chain config
+	chainConfig := lens.ChainClientConfig{
+		Key:            "anon",
+		ChainID:        chainID,
+		RPCAddr:        chain.RPC,
+		AccountPrefix:  chain.Prefix,
+		KeyringBackend: "memory",
+		GasAdjustment:  1.5,
+		Debug:          true,
+		Timeout:        "5s",
+		OutputFormat:   "json",
+		SignModeStr:    "direct",
+		Modules:        lens.ModuleBasics,
+	}
+	chainConfig.Key = "anon"
 
-		chain.client = &customlens.CustomChainClient{ChainClient: c}
+	// Creates client object to pull chain info
+	c, err := lens.NewChainClient(&chainConfig, "", os.Stdin, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return chain.client
+
+	return customlens.NewCustomChainClient(c)
+}
+
+// AccountCount returns how many accounts sign the faucet txs of the chain
+func (chain *Chain) AccountCount() int {
+	chain.GetClient()
+	return len(chain.clients)
 }
 
+// ImportMnemonic derives the key of every faucet account from mnemonic
 func (chain *Chain) ImportMnemonic(mnemonic string) error {
-	_, err := chain.GetClient().KeyAddOrRestore("anon", chain.CoinType, mnemonic)
-	if err != nil {
-		return err
+	chain.GetClient()
+	for i, c := range chain.clients {
+		path := hd.CreateHDPath(chain.CoinType, 0, uint32(i)).String()
+		_, err := c.Keybase.NewAccount("anon", mnemonic, "", path, hd.Secp256k1)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (chain Chain) MultiSend(toAddr []cosmostypes.AccAddress, coins []cosmostypes.Coins, fees cosmostypes.Coins) (error, string) {
+// MultiSend pays coins[i] to toAddr[i] in one tx. broadcast, if set, is called with the tx hash
+// before waiting for the tx to be committed, so the hash can be recorded in case the process stops.
+func (chain Chain) MultiSend(toAddr []cosmostypes.AccAddress, coins []cosmostypes.Coins, fees cosmostypes.Coins, broadcast func(txHash string)) (error, TxResult) {
+	idx, c := chain.signers.acquire()
+	defer chain.signers.release(idx)
+	req, err := chain.multiSendMsg(c, toAddr, coins)
+	if err != nil {
+		return err, TxResult{}
+	}
+	return chain.sendMsg(req, fees, c, broadcast)
+}
+
+// EstimateMultiSend simulates a multi-send and returns the gas it would use
+func (chain Chain) EstimateMultiSend(toAddr []cosmostypes.AccAddress, coins []cosmostypes.Coins) (uint64, error) {
 	c := chain.GetClient()
+	req, err := chain.multiSendMsg(c, toAddr, coins)
+	if err != nil {
+		return 0, err
+	}
+	return c.EstimateGas([]cosmostypes.Msg{req})
+}
+
+func (chain Chain) multiSendMsg(c *customlens.CustomChainClient, toAddr []cosmostypes.AccAddress, coins []cosmostypes.Coins) (*banktypes.MsgMultiSend, error) {
 	faucetRawAddr, err := c.GetKeyAddress()
 	if err != nil {
-		return err, ""
+		return nil, err
 	}
 	faucetAddrStr, err := c.EncodeBech32AccAddr(faucetRawAddr)
 	if err != nil {
-		return err, ""
+		return nil, err
 	}
 
 	var inputs []banktypes.Input
@@ -108,19 +271,93 @@ func (chain Chain) MultiSend(toAddr []cosmostypes.AccAddress, coins []cosmostype
 	for i := range toAddr {
 		recipient, err := c.EncodeBech32AccAddr(toAddr[i])
 		if err != nil {
-			return err, ""
+			return nil, err
 		}
 		log.Infof("Multi sending %s from faucet address [%s] to recipient [%s]",
 			coins[i], faucetAddrStr, recipient)
 		inputs = append(inputs, banktypes.Input{Address: faucetAddrStr, Coins: coins[i]})
 		outputs = append(outputs, banktypes.Output{Address: recipient, Coins: coins[i]})
 	}
-	req := &banktypes.MsgMultiSend{
+	return &banktypes.MsgMultiSend{
 		Inputs:  inputs,
 		Outputs: outputs,
+	}, nil
+}
+
+func accountAddr(c *customlens.CustomChainClient) (string, error) {
+	faucetRawAddr, err := c.GetKeyAddress()
+	if err != nil {
+		return "", err
 	}
+	return c.EncodeBech32AccAddr(faucetRawAddr)
+}
 
-	return chain.sendMsg(req, fees, c)
+// Balances returns what the faucet accounts hold together
+func (chain Chain) Balances(ctx context.Context) (cosmostypes.Coins, error) {
+	accounts, err := chain.AccountBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	total := cosmostypes.NewCoins()
+	for _, account := range accounts {
+		total = total.Add(account.Coins...)
+	}
+	return total, nil
+}
+
+// AccountBalances queries every balance of each faucet account through the chain client's bank query
+func (chain Chain) AccountBalances(ctx context.Context) ([]AccountBalance, error) {
+	chain.GetClient()
+	accounts := make([]AccountBalance, 0, len(chain.clients))
+	for _, c := range chain.clients {
+		addr, err := accountAddr(c)
+		if err != nil {
+			return nil, err
+		}
+		coins, err := chain.balances(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, AccountBalance{Address: addr, Coins: coins})
+	}
+	return accounts, nil
+}
+
+func (chain Chain) balances(ctx context.Context, addr string) (cosmostypes.Coins, error) {
+	queryClient := banktypes.NewQueryClient(chain.GetClient().ChainClient)
+	req := &banktypes.QueryAllBalancesRequest{Address: addr, Pagination: &query.PageRequest{}}
+	var balances cosmostypes.Coins
+	for {
+		res, err := queryClient.AllBalances(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, res.Balances...)
+		if res.Pagination == nil || len(res.Pagination.NextKey) == 0 {
+			return balances, nil
+		}
+		req.Pagination.Key = res.Pagination.NextKey
+	}
+}
+
+// FormatCoin shows coin in the chain's display denom when it is the chain's base denom
+func (chain Chain) FormatCoin(coin cosmostypes.Coin) string {
+	if chain.Denom == "" || coin.Denom != chain.Denom || chain.DisplayDenom == "" {
+		return coin.String()
+	}
+	amount := coin.Amount.String()
+	decimals := int(chain.Decimals)
+	if decimals == 0 {
+		return amount + " " + chain.DisplayDenom
+	}
+	if len(amount) <= decimals {
+		amount = strings.Repeat("0", decimals-len(amount)+1) + amount
+	}
+	whole, frac := amount[:len(amount)-decimals], strings.TrimRight(amount[len(amount)-decimals:], "0")
+	if frac == "" {
+		return whole + " " + chain.DisplayDenom
+	}
+	return whole + "." + frac + " " + chain.DisplayDenom
 }
 
 func (chain Chain) DecodeAddr(a string) (cosmostypes.AccAddress, error) {
@@ -128,15 +365,16 @@ func (chain Chain) DecodeAddr(a string) (cosmostypes.AccAddress, error) {
 	return c.DecodeBech32AccAddr(a)
 }
 
-func (chain Chain) Send(toAddr string, coins cosmostypes.Coins, fees cosmostypes.Coins) (error, string) {
-	c := chain.GetClient()
+func (chain Chain) Send(toAddr string, coins cosmostypes.Coins, fees cosmostypes.Coins) (error, TxResult) {
+	idx, c := chain.signers.acquire()
+	defer chain.signers.release(idx)
 	faucetRawAddr, err := c.GetKeyAddress()
 	if err != nil {
-		return err, ""
+		return err, TxResult{}
 	}
 	faucetAddr, err := c.EncodeBech32AccAddr(faucetRawAddr)
 	if err != nil {
-		return err, ""
+		return err, TxResult{}
 	}
 
 	log.Infof("Sending %s from faucet address [%s] to recipient [%s]", coins, faucetAddr, toAddr)
@@ -146,18 +384,61 @@ func (chain Chain) Send(toAddr string, coins cosmostypes.Coins, fees cosmostypes
 		Amount:      coins,
 	}
 
-	return chain.sendMsg(req, fees, c)
+	return chain.sendMsg(req, fees, c, nil)
 }
 
-func (chain Chain) sendMsg(msg cosmostypes.Msg, fees cosmostypes.Coins, c *customlens.CustomChainClient) (error, string) {
+// sendMsg broadcasts msg and waits until it is committed, failed or the confirm timeout passed.
+// broadcast, if set, is told the hash of the tx once it may have reached the node.
+func (chain Chain) sendMsg(msg cosmostypes.Msg, fees cosmostypes.Coins, c *customlens.CustomChainClient, broadcast func(txHash string)) (error, TxResult) {
 	res, err := c.SendMsg(context.Background(), msg, fees.String())
+	if errors.Is(err, customlens.ErrBroadcastUnknown) {
+		// sending the outputs again could pay them twice, so look for the tx like for a broadcast one
+		log.Warnf("%s broadcast of tx %s failed, waiting in case it reached the node: %v", chain.Prefix, res.TxHash, err)
+	} else if err != nil {
+		if res != nil {
+			// the tx was rejected by CheckTx, keep the hash for the record
+			return err, TxResult{Hash: res.TxHash, Code: res.Code}
+		}
+		return err, TxResult{}
+	}
+	sent := TxResult{Hash: res.TxHash}
+	if broadcast != nil {
+		broadcast(sent.Hash)
+	}
+
+	timeout, err := chain.Confirmation()
 	if err != nil {
-		return err, ""
+		return err, sent
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	res, err = c.WaitForTx(ctx, res.TxHash)
+	if ctx.Err() != nil {
+		log.Warnf("%s tx %s not committed after %s", chain.Prefix, sent.Hash, timeout)
+		return fmt.Errorf("%w after %s", ErrUnconfirmed, timeout), sent
+	}
+	if res == nil {
+		return err, sent
+	}
+	result := TxResult{Hash: res.TxHash, Height: res.Height, GasUsed: res.GasUsed, Code: res.Code}
+	if err != nil {
+		// failed in DeliverTx, the fees were paid
+		return err, result
 	}
 	fmt.Println(c.PrintTxResponse(res))
+	return nil, result
+}
 
-	//TODO: Return tx hash
-	return nil, res.TxHash
+// FindTx looks up a tx broadcast earlier, such as by a process which stopped before it was
+// committed. It returns ErrUnconfirmed while the tx isn't in a block, and the error of the tx when
+// it failed in DeliverTx.
+func (chain Chain) FindTx(ctx context.Context, txHash string) (error, TxResult) {
+	res, err := chain.GetClient().FindTx(ctx, txHash)
+	if res == nil {
+		log.Warnf("%s tx %s not found: %v", chain.Prefix, txHash, err)
+		return ErrUnconfirmed, TxResult{Hash: txHash}
+	}
+	return err, TxResult{Hash: res.TxHash, Height: res.Height, GasUsed: res.GasUsed, Code: res.Code}
 }
 
 func getChainID(rpcUrl string) (string, error) {
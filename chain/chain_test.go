@@ -0,0 +1,23 @@
+package chain
+
+import "testing"
+
+func TestGasLimit(t *testing.T) {
+	cases := []struct {
+		name     string
+		blockGas uint64
+		maxGas   uint64
+		want     uint64
+	}{
+		{"neither set", 0, 0, 0},
+		{"block gas limit", 10000000, 0, 10000000},
+		{"max_gas without a block limit", 0, 2000000, 2000000},
+		{"max_gas below the block limit", 10000000, 2000000, 2000000},
+		{"block limit below max_gas", 1000000, 2000000, 1000000},
+	}
+	for _, c := range cases {
+		if got := gasLimit(c.blockGas, c.maxGas); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
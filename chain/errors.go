@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/umee-network/fonzie/customlens"
+)
+
+// transientTxErrors are tx failures which may succeed when the same tx is sent again. Out of gas
+// isn't one, the same gas estimate would fail again and pay the fees each time.
+var transientTxErrors = []error{
+	sdkerrors.ErrWrongSequence,
+	sdkerrors.ErrMempoolIsFull,
+	sdkerrors.ErrTxTimeoutHeight,
+}
+
+// batchTxErrors are tx failures caused by the faucet account or the fees rather than by one of
+// the recipients, so any part of the batch would fail the same way.
+var batchTxErrors = []error{
+	sdkerrors.ErrInsufficientFunds,
+	sdkerrors.ErrInsufficientFee,
+	// the signer was never funded
+	sdkerrors.ErrUnknownAddress,
+	sdkerrors.ErrInvalidPubKey,
+}
+
+// FailsWholeBatch reports whether err is caused by the faucet side of a multi-send, such as an
+// underfunded signer or too low fees, so splitting the batch can't single out a bad recipient.
+func FailsWholeBatch(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, txErr := range batchTxErrors {
+		// simulation errors come back as strings from the gRPC query
+		if errors.Is(err, txErr) || strings.Contains(err.Error(), txErr.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTransient reports whether err is likely caused by the node or the network rather than the
+// content of the tx, so sending the same tx again may succeed. Network errors only come from the
+// queries before the broadcast, a failed broadcast is waited for like an unconfirmed tx.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrUnconfirmed) || errors.Is(err, customlens.ErrBroadcastUnknown) {
+		return false
+	}
+	for _, txErr := range transientTxErrors {
+		if errors.Is(err, txErr) {
+			return true
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+			return true
+		}
+	}
+	// the RPC client doesn't always wrap connection errors
+	msg := err.Error()
+	return strings.Contains(msg, "connection refused") || strings.Contains(msg, "connection reset") || strings.Contains(msg, "EOF")
+}
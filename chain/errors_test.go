@@ -0,0 +1,65 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/umee-network/fonzie/customlens"
+)
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{sdkerrors.Wrap(sdkerrors.ErrWrongSequence, "account sequence mismatch, expected 4, got 3"), true},
+		{sdkerrors.ErrMempoolIsFull, true},
+		{sdkerrors.ErrTxTimeoutHeight, true},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), true},
+		{status.Error(codes.Unavailable, "node is down"), true},
+		{errors.New("post failed: dial tcp: connection refused"), true},
+		// the same gas estimate would run out again
+		{sdkerrors.ErrOutOfGas, false},
+		{sdkerrors.ErrInsufficientFunds, false},
+		{sdkerrors.Wrap(sdkerrors.ErrUnauthorized, "blocked address"), false},
+		// the tx may still be committed, sending it again could pay twice
+		{fmt.Errorf("%w: tx ABC", ErrUnconfirmed), false},
+		{fmt.Errorf("%w: connection reset", customlens.ErrBroadcastUnknown), false},
+	}
+	for _, c := range cases {
+		if got := IsTransient(c.err); got != c.transient {
+			t.Errorf("IsTransient(%v) = %v, want %v", c.err, got, c.transient)
+		}
+	}
+}
+
+func TestFailsWholeBatch(t *testing.T) {
+	cases := []struct {
+		err   error
+		batch bool
+	}{
+		{nil, false},
+		{sdkerrors.Wrap(sdkerrors.ErrInsufficientFunds, "1000uumee is smaller than 5000uumee"), true},
+		{fmt.Errorf("transaction failed with code 13: %w", sdkerrors.ErrInsufficientFee), true},
+		{sdkerrors.Wrap(sdkerrors.ErrUnknownAddress, "account umee1abc does not exist"), true},
+		// simulation errors are only strings
+		{errors.New("rpc error: code = Unknown desc = 1000uumee is smaller than 5000uumee: insufficient funds: invalid request"), true},
+		// a blocked recipient is singled out by splitting the batch
+		{sdkerrors.Wrap(sdkerrors.ErrUnauthorized, "umee1abc is not allowed to receive funds"), false},
+		{errors.New("rpc error: code = Unknown desc = umee1abc is not allowed to receive funds: unauthorized"), false},
+		{sdkerrors.ErrOutOfGas, false},
+		{sdkerrors.ErrMempoolIsFull, false},
+	}
+	for _, c := range cases {
+		if got := FailsWholeBatch(c.err); got != c.batch {
+			t.Errorf("FailsWholeBatch(%v) = %v, want %v", c.err, got, c.batch)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package chain
+
+import (
+	"sync"
+
+	"github.com/umee-network/fonzie/customlens"
+)
+
+// signerPool hands out the faucet accounts of a chain, so batches are signed by different
+// accounts and a stuck sequence only stalls one of them
+type signerPool struct {
+	mu      sync.Mutex
+	clients []*customlens.CustomChainClient
+	busy    []int
+	next    int
+}
+
+func newSignerPool(clients []*customlens.CustomChainClient) *signerPool {
+	return &signerPool{clients: clients, busy: make([]int, len(clients))}
+}
+
+// acquire returns the account with the fewest txs in flight, taking turns between idle ones.
+// It must be given back with release.
+func (p *signerPool) acquire() (int, *customlens.CustomChainClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	best := -1
+	for i := range p.clients {
+		idx := (p.next + i) % len(p.clients)
+		if best == -1 || p.busy[idx] < p.busy[best] {
+			best = idx
+		}
+	}
+	p.busy[best]++
+	p.next = (best + 1) % len(p.clients)
+	return best, p.clients[best]
+}
+
+func (p *signerPool) release(idx int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy[idx]--
+}
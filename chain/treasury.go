@@ -0,0 +1,195 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/crypto/hd"
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTopUpInterval = time.Minute
+	// refillDropped is how long a refill may stay out of a block before it is taken as dropped
+	// from the mempool and the account is refilled again
+	refillDropped = 10 * time.Minute
+)
+
+// TopUp refills the faucet accounts from a treasury account. For every denom of Amount,
+// an account holding less than its Threshold gets Amount of it.
+type TopUp struct {
+	Threshold string `json:"threshold"`
+	Amount    string `json:"amount"`
+	Fees      string `json:"fees"`
+	// Interval is how often the balances are checked, e.g. `1m`
+	Interval string `json:"interval"`
+}
+
+// Refill is a top-up of one faucet account
+type Refill struct {
+	Address string
+	Coins   cosmostypes.Coins
+	Tx      TxResult
+	Err     error
+}
+
+// pendingRefills are the refills broadcast but not seen in a block yet, by faucet account.
+// The balance of such an account doesn't tell if it still needs a refill.
+type pendingRefills struct {
+	mu  sync.Mutex
+	txs map[string]pendingRefill
+}
+
+type pendingRefill struct {
+	hash   string
+	sentAt time.Time
+}
+
+func newPendingRefills() *pendingRefills {
+	return &pendingRefills{txs: map[string]pendingRefill{}}
+}
+
+func (p *pendingRefills) add(address string, hash string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.txs[address] = pendingRefill{hash: hash, sentAt: now}
+}
+
+// waiting returns the accounts whose refill is still waiting to be committed, looking the txs up
+// with find. Committed, failed and dropped refills are forgotten.
+func (p *pendingRefills) waiting(now time.Time, find func(txHash string) (error, TxResult)) map[string]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	waiting := map[string]bool{}
+	for address, refill := range p.txs {
+		err, _ := find(refill.hash)
+		if errors.Is(err, ErrUnconfirmed) {
+			if now.Sub(refill.sentAt) < refillDropped {
+				waiting[address] = true
+				continue
+			}
+			log.Warnf("refill %s of %s not committed after %s, taking it as dropped", refill.hash, address, refillDropped)
+		} else if err != nil {
+			log.Warnf("refill %s of %s failed: %v", refill.hash, address, err)
+		}
+		delete(p.txs, address)
+	}
+	return waiting
+}
+
+// parse returns the threshold, amount, fees and check interval of the top-up
+func (t TopUp) parse() (cosmostypes.Coins, cosmostypes.Coins, cosmostypes.Coins, time.Duration, error) {
+	threshold, err := cosmostypes.ParseCoinsNormalized(t.Threshold)
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("invalid threshold: %w", err)
+	}
+	amount, err := cosmostypes.ParseCoinsNormalized(t.Amount)
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("invalid amount: %w", err)
+	}
+	if amount.IsZero() {
+		return nil, nil, nil, 0, fmt.Errorf("amount can't be empty")
+	}
+	fees, err := cosmostypes.ParseCoinsNormalized(t.Fees)
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("invalid fees: %w", err)
+	}
+	interval := defaultTopUpInterval
+	if t.Interval != "" {
+		interval, err = time.ParseDuration(t.Interval)
+		if err != nil {
+			return nil, nil, nil, 0, fmt.Errorf("invalid interval: %w", err)
+		}
+		if interval <= 0 {
+			return nil, nil, nil, 0, fmt.Errorf("interval must be positive")
+		}
+	}
+	return threshold, amount, fees, interval, nil
+}
+
+// TopUpInterval returns how often the faucet balances are checked, or 0 when the chain has no top-up
+func (chain Chain) TopUpInterval() (time.Duration, error) {
+	if chain.TopUp == nil {
+		return 0, nil
+	}
+	_, _, _, interval, err := chain.TopUp.parse()
+	if err != nil {
+		return 0, fmt.Errorf("invalid top_up for %s: %w", chain.Prefix, err)
+	}
+	return interval, nil
+}
+
+// ImportTreasury derives the treasury key from mnemonic, at HD index 0
+func (chain *Chain) ImportTreasury(mnemonic string) error {
+	primary := chain.GetClient()
+	c := newClient(chain, primary.Config.ChainID)
+	path := hd.CreateHDPath(chain.CoinType, 0, 0).String()
+	if _, err := c.Keybase.NewAccount("anon", mnemonic, "", path, hd.Secp256k1); err != nil {
+		return err
+	}
+	chain.treasury = c
+	chain.refills = newPendingRefills()
+	return nil
+}
+
+// TreasuryAddr returns the bech32 address of the treasury, or "" when there is none
+func (chain Chain) TreasuryAddr() (string, error) {
+	if chain.treasury == nil {
+		return "", nil
+	}
+	return accountAddr(chain.treasury)
+}
+
+// RefillAccounts sends the top-up amount from the treasury to every faucet account below the threshold.
+// Accounts whose last refill is still waiting to be committed are skipped, so they aren't paid twice.
+func (chain Chain) RefillAccounts(ctx context.Context) ([]Refill, error) {
+	if chain.TopUp == nil || chain.treasury == nil {
+		return nil, nil
+	}
+	threshold, amount, fees, _, err := chain.TopUp.parse()
+	if err != nil {
+		return nil, err
+	}
+	// looked up first, so the balances include the refills committed since
+	waiting := chain.refills.waiting(time.Now(), func(txHash string) (error, TxResult) {
+		return chain.FindTx(ctx, txHash)
+	})
+	accounts, err := chain.AccountBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	from, err := accountAddr(chain.treasury)
+	if err != nil {
+		return nil, err
+	}
+
+	var refills []Refill
+	for _, account := range accounts {
+		if waiting[account.Address] {
+			log.Infof("%s faucet account %s is still waiting for its refill", chain.Prefix, account.Address)
+			continue
+		}
+		var coins cosmostypes.Coins
+		for _, coin := range amount {
+			if account.Coins.AmountOf(coin.Denom).LT(threshold.AmountOf(coin.Denom)) {
+				coins = coins.Add(coin)
+			}
+		}
+		if coins.Empty() {
+			continue
+		}
+		msg := &banktypes.MsgSend{FromAddress: from, ToAddress: account.Address, Amount: coins}
+		err, tx := chain.sendMsg(msg, fees, chain.treasury, nil)
+		if errors.Is(err, ErrUnconfirmed) {
+			// it may still be committed, the next checks look it up before sending another one
+			chain.refills.add(account.Address, tx.Hash, time.Now())
+		}
+		refills = append(refills, Refill{Address: account.Address, Coins: coins, Tx: tx, Err: err})
+	}
+	return refills, nil
+}
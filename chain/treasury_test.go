@@ -0,0 +1,44 @@
+package chain
+
+import (
+	"testing"
+	"time"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+func TestPendingRefills(t *testing.T) {
+	now := time.Now()
+	p := newPendingRefills()
+	p.add("committed", "A", now)
+	p.add("failed", "B", now)
+	p.add("waiting", "C", now)
+	p.add("dropped", "D", now.Add(-refillDropped))
+
+	var looked int
+	find := func(txHash string) (error, TxResult) {
+		looked++
+		switch txHash {
+		case "A":
+			return nil, TxResult{Hash: txHash, Height: 7}
+		case "B":
+			return sdkerrors.ErrInsufficientFunds, TxResult{Hash: txHash, Height: 7}
+		}
+		return ErrUnconfirmed, TxResult{Hash: txHash}
+	}
+	if waiting := p.waiting(now, find); len(waiting) != 1 || !waiting["waiting"] {
+		t.Errorf("waiting for %v, want only the uncommitted refill", waiting)
+	}
+	if looked != 4 {
+		t.Errorf("looked up %d txs, want 4", looked)
+	}
+
+	// settled refills are forgotten, the uncommitted one is looked up until it is taken as dropped
+	looked = 0
+	if waiting := p.waiting(now.Add(refillDropped), find); len(waiting) != 0 {
+		t.Errorf("still waiting for %v", waiting)
+	}
+	if looked != 1 || len(p.txs) != 0 {
+		t.Errorf("looked up %d txs and kept %v, want 1 and none", looked, p.txs)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Entrio/subenv"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/umee-network/fonzie/chain"
+	"github.com/umee-network/fonzie/db"
+)
+
+// ChatMessage is a message received on a chat platform
+type ChatMessage struct {
+	ID        string
+	ChannelID string
+	UserID    string
+	// UserName is the display name of the author, if the platform has one besides UserID
+	UserName string
+	Text     string
+	IsDM     bool
+	IsBot    bool
+}
+
+// ChatClient answers messages on a chat platform
+type ChatClient interface {
+	// Reply answers m in its channel
+	Reply(m ChatMessage, text string) error
+	AddReaction(m ChatMessage, reaction string) error
+	RemoveReaction(m ChatMessage, reaction string) error
+	// DirectMessage privately messages the author of m
+	DirectMessage(m ChatMessage, text string) error
+	// Mention returns how to address the author of m in a reply
+	Mention(m ChatMessage) string
+}
+
+// ChatAdapter is a chat platform the faucet listens on for `!` commands
+type ChatAdapter interface {
+	ChatClient
+	// Name identifies the adapter in the queued requests, e.g. `matrix`
+	Name() string
+	// IDPrefix namespaces requester IDs, e.g. `matrix` for `matrix:@alice:matrix.org`
+	IDPrefix() string
+	// Listen passes every received message to handle until ctx is done
+	Listen(ctx context.Context, handle func(ChatMessage)) error
+}
+
+// chatCmd is a command received as a chat message. It applies the bot and silent mode rules
+// shared by all platforms before calling the platform's client.
+type chatCmd struct {
+	client ChatClient
+	// platform names the adapter in ReplyRef
+	platform  string
+	msg       ChatMessage
+	requester Requester
+}
+
+func (c chatCmd) reply(msg string) error {
+	return c.client.Reply(c.msg, msg)
+}
+
+func (c chatCmd) reportError(errToReport error) {
+	if c.msg.IsBot {
+		// guard against known bots
+		return
+	}
+	err := c.sendReaction("❌")
+	if err != nil {
+		log.Error(err)
+	}
+	// Send errors to channel, even when isSilent
+	err = c.reply(fmt.Sprintf("%s, there is an error in your request:\n `%s`", c.client.Mention(c.msg), errToReport))
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (c chatCmd) sendMessage(msg string) error {
+	if c.msg.IsBot || isSilent && !c.msg.IsDM {
+		// Silent mode is enabled, so-- only reply to DMs
+		return nil
+	}
+	return c.client.DirectMessage(c.msg, msg)
+}
+
+func (c chatCmd) sendReaction(reaction string) error {
+	if isSilent || c.msg.IsBot {
+		return nil
+	}
+	return c.client.AddReaction(c.msg, reaction)
+}
+
+func (c chatCmd) removedReaction(reaction string) error {
+	if isSilent || c.msg.IsBot {
+		return nil
+	}
+	return c.client.RemoveReaction(c.msg, reaction)
+}
+
+func (c chatCmd) Funded(r FaucetReq, tx chain.TxResult) {
+	// Everything worked, so-- respond successfully to the requester
+	c.sendReaction("✅")
+	c.removedReaction("⚙️")
+	err := c.reply(fmt.Sprintf("Hey %s, faucet tapped, just for you!\nConfirmed in block %d\nTransaction hash\n%s/%s", c.client.Mention(c.msg), tx.Height, finderURL(), tx.Hash))
+	if err != nil {
+		log.Error(err)
+	}
+	if subenv.EnvB("SEND_DM", false) {
+		c.sendMessage(fmt.Sprintf("Dispensed 💸 `%s` to `%s`\n%s", r.Coins, r.Recipient, fmt.Sprintf("Transaction hash\n%s/%s", finderURL(), tx.Hash)))
+	}
+}
+
+func (c chatCmd) Unconfirmed(r FaucetReq, txHash string) {
+	c.sendReaction("⏳")
+	c.removedReaction("⚙️")
+	err := c.reply(fmt.Sprintf("Hey %s, your funding was sent but isn't confirmed yet, check the transaction in a moment\n%s/%s", c.client.Mention(c.msg), finderURL(), txHash))
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (c chatCmd) Merged(r FaucetReq, recipient string) {
+	c.sendReaction("🔁")
+	c.removedReaction("⚙️")
+	err := c.reply(fmt.Sprintf("Hey %s, your request was merged with another one in the same batch, the funds go to %s", c.client.Mention(c.msg), recipient))
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (c chatCmd) Failed(r FaucetReq, err error) {
+	c.reportError(err)
+}
+
+func (c chatCmd) ReplyRef() db.ReplyRef {
+	return db.ReplyRef{
+		Frontend:  c.platform,
+		ChannelID: c.msg.ChannelID,
+		MessageID: c.msg.ID,
+		UserID:    c.msg.UserID,
+		IsDM:      c.msg.IsDM,
+	}
+}
+
+// chatResponder answers a request replayed from the queue where it was made
+func chatResponder(adapter ChatAdapter) ResponderFactory {
+	return func(ref db.ReplyRef, name string) Responder {
+		return chatCmd{
+			client:   adapter,
+			platform: adapter.Name(),
+			msg: ChatMessage{
+				ID:        ref.MessageID,
+				ChannelID: ref.ChannelID,
+				UserID:    ref.UserID,
+				UserName:  name,
+				IsDM:      ref.IsDM,
+			},
+		}
+	}
+}
+
+// handleChat serves `!` commands received through a chat adapter. These platforms have no
+// roles, so role gated chains are refused and everyone gets the default funding.
+func (fh FaucetHandler) handleChat(adapter ChatAdapter, m ChatMessage) {
+	if m.IsBot {
+		return
+	}
+	c := chatCmd{
+		client:    adapter,
+		platform:  adapter.Name(),
+		msg:       m,
+		requester: Requester{ID: adapter.IDPrefix() + ":" + m.UserID, Name: m.UserID},
+	}
+	if m.UserName != "" {
+		c.requester.Name = m.UserName
+	}
+
+	matches := fh.cmd.FindAllStringSubmatch(m.Text, -1)
+	if len(matches) > maxCommandsPerMessage {
+		c.reportError(errTooManyCommands)
+		return
+	}
+	if len(matches) == 0 {
+		if m.IsDM {
+			// If message is DM, respond with help
+			c.sendMessage(helpText(fh.chains))
+		}
+		return
+	}
+	for _, match := range matches {
+		cmd := strings.TrimSpace(match[1])
+		args := strings.TrimSpace(match[2])
+		switch cmd {
+		case "request":
+			if !fh.requestChat(c, args) {
+				return
+			}
+		case "status":
+			c.sendReaction("⚙️")
+			report, err := fh.statusReport(args)
+			c.removedReaction("⚙️")
+			if err != nil {
+				c.reportError(err)
+				continue
+			}
+			c.sendReaction("✅")
+			if err := c.reply("Faucet status:\n" + report); err != nil {
+				log.Error(err)
+			}
+		default:
+			if err := c.sendMessage(helpText(fh.chains)); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// requestChat queues a funding request received through a chat adapter.
+// It returns false when the request was rejected.
+func (fh FaucetHandler) requestChat(c chatCmd, dstAddr string) bool {
+	faucet, req, err := fh.prepareDefault(c.requester, dstAddr, "", false)
+	if err != nil {
+		c.reportError(err)
+		return false
+	}
+	req.Responder = c
+
+	c.sendReaction("👍")
+	c.sendReaction("⚙️")
+	if err := fh.enqueue(faucet, req); err != nil {
+		c.removedReaction("⚙️")
+		c.reportError(err)
+		return false
+	}
+	return true
+}
+
+// runChatAdapter listens on adapter until ctx is done
+func runChatAdapter(ctx context.Context, fh FaucetHandler, adapter ChatAdapter) {
+	log.Infof("%s chat adapter started", adapter.Name())
+	err := adapter.Listen(ctx, func(m ChatMessage) {
+		go fh.handleChat(adapter, m)
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Fatalf("%s chat adapter stopped: %v", adapter.Name(), err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/umee-network/fonzie/chain"
+)
+
+// apiCall is a request received by a fakeAPI
+type apiCall struct {
+	Method string
+	Path   string
+	Query  string
+	Auth   string
+	Body   map[string]interface{}
+}
+
+// fakeAPI is a chat platform API recording the calls made to it
+type fakeAPI struct {
+	*httptest.Server
+	// Mux serves the recorded API on "/", tests may add other endpoints
+	Mux *http.ServeMux
+
+	mu    sync.Mutex
+	calls []apiCall
+}
+
+// longPoll is returned by a fakeAPI handler to hold the request until the client gives up
+const longPoll = -1
+
+// newFakeAPI serves the calls with handle, which returns the status code and the JSON result.
+// handle is called with the lock of the fakeAPI held, so it may keep state across calls.
+func newFakeAPI(t *testing.T, handle func(c apiCall) (int, interface{})) *fakeAPI {
+	f := &fakeAPI{Mux: http.NewServeMux()}
+	f.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		c := apiCall{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.RawQuery,
+			Auth:   strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		}
+		if err := json.NewDecoder(r.Body).Decode(&c.Body); err != nil && !errors.Is(err, io.EOF) {
+			t.Errorf("%s %s body: %v", c.Method, c.Path, err)
+		}
+		f.mu.Lock()
+		f.calls = append(f.calls, c)
+		status, result := handle(c)
+		f.mu.Unlock()
+
+		if status == longPoll {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	})
+	f.Server = httptest.NewServer(f.Mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// called returns the calls with method whose path starts with prefix
+func (f *fakeAPI) called(method string, prefix string) []apiCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []apiCall
+	for _, c := range f.calls {
+		if c.Method == method && strings.HasPrefix(c.Path, prefix) {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// locked runs fn with the lock of the handler state held
+func (f *fakeAPI) locked(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+// waitFor polls cond until it holds or a few seconds passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// listen runs adapter.Listen until the wanted messages were handled and settled holds, if set,
+// then stops it
+func listen(t *testing.T, adapter ChatAdapter, want []ChatMessage, settled func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages := make(chan ChatMessage, len(want)+1)
+	done := make(chan error)
+	go func() {
+		done <- adapter.Listen(ctx, func(m ChatMessage) { messages <- m })
+	}()
+
+	for _, w := range want {
+		select {
+		case m := <-messages:
+			if m != w {
+				t.Errorf("got %+v, want %+v", m, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no message received, want %+v", w)
+		}
+	}
+
+	if settled != nil {
+		waitFor(t, "the adapter to settle", settled)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Listen returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen didn't return after cancel")
+	}
+	select {
+	case m := <-messages:
+		t.Errorf("unexpected message %+v", m)
+	default:
+	}
+}
+
+// chatLog is a ChatClient recording what it was asked to do
+type chatLog []string
+
+func (l *chatLog) Reply(m ChatMessage, text string) error {
+	*l = append(*l, "reply "+text)
+	return nil
+}
+
+func (l *chatLog) AddReaction(m ChatMessage, reaction string) error {
+	*l = append(*l, "add "+reaction)
+	return nil
+}
+
+func (l *chatLog) RemoveReaction(m ChatMessage, reaction string) error {
+	*l = append(*l, "remove "+reaction)
+	return nil
+}
+
+func (l *chatLog) DirectMessage(m ChatMessage, text string) error {
+	*l = append(*l, "dm "+text)
+	return nil
+}
+
+func (l *chatLog) Mention(m ChatMessage) string {
+	return "@" + m.UserID
+}
+
+func TestChatCmdOutcomes(t *testing.T) {
+	t.Setenv("FINDER_URL", "https://finder/tx")
+	cases := []struct {
+		name    string
+		respond func(c chatCmd)
+		want    []string
+	}{
+		{"funded", func(c chatCmd) { c.Funded(FaucetReq{}, chain.TxResult{Hash: "ABC", Height: 7}) }, []string{
+			"add ✅", "remove ⚙️", "reply Hey @alice, faucet tapped, just for you!\nConfirmed in block 7\nTransaction hash\nhttps://finder/tx/ABC",
+		}},
+		{"unconfirmed", func(c chatCmd) { c.Unconfirmed(FaucetReq{}, "ABC") }, []string{
+			"add ⏳", "remove ⚙️", "reply Hey @alice, your funding was sent but isn't confirmed yet, check the transaction in a moment\nhttps://finder/tx/ABC",
+		}},
+		{"merged", func(c chatCmd) { c.Merged(FaucetReq{}, "umee1abc") }, []string{
+			"add 🔁", "remove ⚙️", "reply Hey @alice, your request was merged with another one in the same batch, the funds go to umee1abc",
+		}},
+		{"failed", func(c chatCmd) { c.Failed(FaucetReq{}, errors.New("boom")) }, []string{
+			"add ❌", "reply @alice, there is an error in your request:\n `boom`",
+		}},
+	}
+	for _, tc := range cases {
+		var l chatLog
+		tc.respond(chatCmd{client: &l, msg: ChatMessage{ID: "1", UserID: "alice"}})
+		if fmt.Sprintf("%q", l) != fmt.Sprintf("%q", tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, l, tc.want)
+		}
+	}
+
+	// bots aren't answered at all
+	var l chatLog
+	chatCmd{client: &l, msg: ChatMessage{UserID: "bot", IsBot: true}}.Failed(FaucetReq{}, errors.New("boom"))
+	if len(l) != 0 {
+		t.Errorf("answered a bot with %q", l)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/umee-network/fonzie/chain"
+)
+
+// slashCommands builds the application commands offered by the bot
+func slashCommands(chains chain.Chains) []*discordgo.ApplicationCommand {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, c := range chains {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: c.Prefix, Value: c.Prefix})
+	}
+	return []*discordgo.ApplicationCommand{
+		{
+			Name:        "request",
+			Description: "Request coins through the faucet",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "address",
+					Description: "Address to fund",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "chain",
+					Description: "Chain of the address, taken from the address prefix when omitted",
+					Choices:     choices,
+				},
+			},
+		},
+		{
+			Name:        "status",
+			Description: "Show the faucet status",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "chain",
+					Description: "Chain to show, all chains when omitted",
+					Choices:     choices,
+				},
+			},
+		},
+		{
+			Name:        "help",
+			Description: "Show how to use the faucet",
+		},
+	}
+}
+
+// registerCommands replaces the bot's application commands, for a single guild when
+// COMMANDS_GUILD_ID is set (changes apply instantly) or globally otherwise.
+func registerCommands(s *discordgo.Session, chains chain.Chains) error {
+	cmds, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, commandsGuildID, slashCommands(chains))
+	if err != nil {
+		return err
+	}
+	log.Infof("registered %d slash commands", len(cmds))
+	return nil
+}
+
+// handleInteraction is called for every slash command used with the bot
+func (fh FaucetHandler) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	c := discordCmd{session: s, interaction: i}
+
+	// Discord wants an answer within 3 seconds, so acknowledge now and edit the response later
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: uint64(discordgo.MessageFlagsEphemeral)},
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	options := make(map[string]string)
+	for _, o := range data.Options {
+		options[o.Name] = o.StringValue()
+	}
+
+	switch data.Name {
+	case "request":
+		fh.request(c, options["address"], options["chain"])
+	case "status":
+		fh.status(c, options["chain"])
+	default:
+		err = c.reply(helpText(fh.chains))
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -2,15 +2,31 @@ package customlens
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/cosmos/cosmos-sdk/client/tx"
 	sdk "github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
 	lens "github.com/strangelove-ventures/lens/client"
 )
 
+// ErrBroadcastUnknown is returned when the broadcast failed without an answer from the node, so the
+// tx may or may not have reached its mempool. The returned response holds the hash to look it up.
+var ErrBroadcastUnknown = errors.New("the transaction broadcast failed, it may still have reached the node")
+
 type CustomChainClient struct {
 	*lens.ChainClient
+	sequence *accountSequence
+}
+
+// NewCustomChainClient wraps a lens client, keeping the account sequence locally
+func NewCustomChainClient(c *lens.ChainClient) *CustomChainClient {
+	return &CustomChainClient{ChainClient: c, sequence: &accountSequence{}}
 }
 
 // SendMsg yeet
@@ -18,9 +34,36 @@ func (cc *CustomChainClient) SendMsg(ctx context.Context, msg sdk.Msg, fees stri
 	return cc.SendMsgs(ctx, []sdk.Msg{msg}, fees)
 }
 
+// EstimateGas simulates msgs and returns the gas SendMsgs would request for them
+func (cc *CustomChainClient) EstimateGas(msgs []sdk.Msg) (uint64, error) {
+	// the node simulates against its mempool state, so the local sequence is the one it expects
+	cc.sequence.mu.Lock()
+	txf, err := cc.factory()
+	cc.sequence.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	_, adjusted, err := cc.ChainClient.CalculateGas(txf, msgs...)
+	return adjusted, err
+}
+
+// MaxBlockGas returns the gas limit of a block, or 0 when the chain has none
+func (cc *CustomChainClient) MaxBlockGas(ctx context.Context) (uint64, error) {
+	res, err := cc.RPCClient.ConsensusParams(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if res.ConsensusParams.Block.MaxGas <= 0 {
+		return 0, nil
+	}
+	return uint64(res.ConsensusParams.Block.MaxGas), nil
+}
+
 // SendMsgs yeet
 func (cc *CustomChainClient) SendMsgs(ctx context.Context, msgs []sdk.Msg, fees string) (*sdk.TxResponse, error) {
-	txf, err := cc.PrepareFactory(cc.TxFactory())
+	cc.sequence.mu.Lock()
+	defer cc.sequence.mu.Unlock()
+	txf, err := cc.factory()
 	if err != nil {
 		return nil, err
 	}
@@ -30,6 +73,11 @@ func (cc *CustomChainClient) SendMsgs(ctx context.Context, msgs []sdk.Msg, fees
 	// https://github.com/cosmos/cosmos-sdk/blob/5725659684fc93790a63981c653feee33ecf3225/client/tx/tx.go#L297
 	_, adjusted, err := cc.ChainClient.CalculateGas(txf, msgs...)
 	if err != nil {
+		if errors.Is(err, sdkerrors.ErrWrongSequence) || strings.Contains(err.Error(), "account sequence mismatch") {
+			// simulation errors come back as strings from the gRPC query, type them for the callers
+			err = fmt.Errorf("%s: %w", err, sdkerrors.ErrWrongSequence)
+			cc.advance(0, err)
+		}
 		return nil, err
 	}
 
@@ -74,18 +122,68 @@ func (cc *CustomChainClient) SendMsgs(ctx context.Context, msgs []sdk.Msg, fees
 		return nil, err
 	}
 
-	// Broadcast those bytes
-	res, err := cc.BroadcastTx(ctx, txBytes)
+	// Broadcast those bytes. Only CheckTx has run when this returns, see WaitForTx.
+	res, err := cc.RPCClient.BroadcastTxSync(ctx, txBytes)
+	if errRes := lens.CheckTendermintError(err, txBytes); errRes != nil {
+		err = txError(errRes)
+		cc.advance(errRes.Code, err)
+		return errRes, err
+	}
+	if err != nil {
+		cc.advance(0, err)
+		// tendermint tx hashes are the sha256 of the tx bytes
+		hash := sha256.Sum256(txBytes)
+		return &sdk.TxResponse{TxHash: strings.ToUpper(hex.EncodeToString(hash[:]))}, fmt.Errorf("%w: %v", ErrBroadcastUnknown, err)
+	}
+	txRes := sdk.NewResponseFormatBroadcastTx(res)
+	err = txError(txRes)
+	cc.advance(txRes.Code, err)
+	return txRes, err
+}
+
+// WaitForTx polls for the tx with the given hash until it is committed in a block or ctx is done.
+// The returned error tells if the tx failed in DeliverTx.
+func (cc *CustomChainClient) WaitForTx(ctx context.Context, txHash string) (*sdk.TxResponse, error) {
+	hash, err := hex.DecodeString(txHash)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case <-time.After(500 * time.Millisecond):
+			if res, err := cc.findTx(ctx, hash); res != nil {
+				return res, err
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// FindTx looks up the tx with the given hash once. The response is nil when the tx isn't in a
+// block or the node couldn't be asked, and the error tells which, or if the tx failed in DeliverTx.
+func (cc *CustomChainClient) FindTx(ctx context.Context, txHash string) (*sdk.TxResponse, error) {
+	hash, err := hex.DecodeString(txHash)
 	if err != nil {
 		return nil, err
 	}
+	return cc.findTx(ctx, hash)
+}
 
-	// transaction was executed, log the success or failure using the tx response code
-	// NOTE: error is nil, logic should use the returned error to determine if the
-	// transaction was successfully executed.
-	if res.Code != 0 {
-		return res, fmt.Errorf("transaction failed with code: %d", res.Code)
+func (cc *CustomChainClient) findTx(ctx context.Context, hash []byte) (*sdk.TxResponse, error) {
+	resTx, err := cc.RPCClient.Tx(ctx, hash, false)
+	if err != nil {
+		return nil, err
 	}
+	res := sdk.NewResponseResultTx(resTx, nil, time.Now().Format(time.RFC3339))
+	return res, txError(res)
+}
 
-	return res, nil
+// txError returns the error of a failed tx response. The registered error is kept so callers
+// can tell why with errors.Is.
+func txError(res *sdk.TxResponse) error {
+	if res.Code == 0 {
+		return nil
+	}
+	return fmt.Errorf("transaction failed with code %d: %w", res.Code, sdkerrors.ABCIError(res.Codespace, res.Code, res.RawLog))
 }
@@ -0,0 +1,61 @@
+package customlens
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/cosmos/cosmos-sdk/client/tx"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+// expectedSequence finds the sequence the node wants in an account sequence mismatch error
+var expectedSequence = regexp.MustCompile(`account sequence mismatch, expected (\d+)`)
+
+// accountSequence tracks the account number and next sequence of the signing key locally,
+// so a tx can be signed before the previous one was committed.
+type accountSequence struct {
+	// mu is held from signing to broadcasting, so sequences reach the node in order
+	mu     sync.Mutex
+	synced bool
+	accNum uint64
+	seq    uint64
+}
+
+// factory returns a tx factory with the local account number and sequence, fetching them
+// from the node first if needed. The caller must hold cc.sequence.mu.
+func (cc *CustomChainClient) factory() (tx.Factory, error) {
+	s := cc.sequence
+	if !s.synced {
+		txf, err := cc.PrepareFactory(cc.TxFactory())
+		if err != nil {
+			return tx.Factory{}, err
+		}
+		s.accNum, s.seq, s.synced = txf.AccountNumber(), txf.Sequence(), true
+	}
+	return cc.TxFactory().WithAccountNumber(s.accNum).WithSequence(s.seq), nil
+}
+
+// advance updates the local sequence after a broadcast. The caller must hold cc.sequence.mu.
+func (cc *CustomChainClient) advance(code uint32, err error) {
+	s := cc.sequence
+	switch {
+	case err == nil && code == 0:
+		// CheckTx passed, the sequence is used even if DeliverTx fails
+		s.seq++
+	case errors.Is(err, sdkerrors.ErrWrongSequence):
+		// the node tells which sequence it expects, mempool txs included
+		if m := expectedSequence.FindStringSubmatch(err.Error()); m != nil {
+			if seq, perr := strconv.ParseUint(m[1], 10, 64); perr == nil {
+				s.seq = seq
+				return
+			}
+		}
+		s.synced = false
+	case err != nil && code == 0:
+		// the node may or may not have the tx, ask again next time
+		s.synced = false
+	}
+	// any other CheckTx failure leaves the sequence unused
+}
@@ -0,0 +1,34 @@
+package customlens
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+func TestAdvance(t *testing.T) {
+	cases := []struct {
+		name   string
+		code   uint32
+		err    error
+		seq    uint64
+		synced bool
+	}{
+		{"passed CheckTx", 0, nil, 8, true},
+		{"expected sequence", 32, sdkerrors.Wrap(sdkerrors.ErrWrongSequence, "account sequence mismatch, expected 12, got 7"), 12, true},
+		{"expected sequence from the node", 32, fmt.Errorf("transaction failed with code 32: %w", sdkerrors.ABCIError(sdkerrors.RootCodespace, 32, "account sequence mismatch, expected 5, got 7: incorrect account sequence")), 5, true},
+		{"no expected sequence", 32, sdkerrors.Wrap(sdkerrors.ErrWrongSequence, "signature verification failed"), 7, false},
+		{"unparsable expected sequence", 32, sdkerrors.Wrap(sdkerrors.ErrWrongSequence, "account sequence mismatch, expected 99999999999999999999, got 7"), 7, false},
+		{"failed CheckTx", 5, sdkerrors.ErrInsufficientFunds, 7, true},
+		{"broadcast unknown", 0, fmt.Errorf("%w: %v", ErrBroadcastUnknown, errors.New("connection reset")), 7, false},
+	}
+	for _, c := range cases {
+		cc := &CustomChainClient{sequence: &accountSequence{synced: true, accNum: 3, seq: 7}}
+		cc.advance(c.code, c.err)
+		if s := cc.sequence; s.seq != c.seq || s.synced != c.synced || s.accNum != 3 {
+			t.Errorf("%s: sequence %d synced %v account %d, want %d synced %v account 3", c.name, s.seq, s.synced, s.accNum, c.seq, c.synced)
+		}
+	}
+}
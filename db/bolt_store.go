@@ -0,0 +1,354 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	receiptsBucket = []byte("receipts")
+	queueBucket    = []byte("queue")
+	// recipientsBucket indexes the receipts by chain and recipient, holding the JSON list of their keys
+	recipientsBucket = []byte("recipients")
+)
+
+// BoltReceiptStore persists receipts to a bbolt file on local disk, so cooldowns survive restarts.
+type BoltReceiptStore struct {
+	bolt *bolt.DB
+}
+
+func NewBoltReceiptStore(path string) (*BoltReceiptStore, error) {
+	b, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = b.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(receiptsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(queueBucket); err != nil {
+			return err
+		}
+		if tx.Bucket(recipientsBucket) != nil {
+			return nil
+		}
+		// files written before the index existed are indexed once
+		if _, err := tx.CreateBucket(recipientsBucket); err != nil {
+			return err
+		}
+		return tx.Bucket(receiptsBucket).ForEach(func(k, v []byte) error {
+			var receipt FundingReceipt
+			if err := json.Unmarshal(v, &receipt); err != nil {
+				return err
+			}
+			return indexRecipient(tx, receipt, k)
+		})
+	})
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return &BoltReceiptStore{bolt: b}, nil
+}
+
+func (s *BoltReceiptStore) Save(ctx context.Context, newReceipt FundingReceipt) error {
+	v, err := json.Marshal(newReceipt)
+	if err != nil {
+		return err
+	}
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		return putReceipt(tx, newReceipt, v)
+	})
+}
+
+// putReceipt stores a receipt and moves its key to the index entry of its recipient
+func putReceipt(tx *bolt.Tx, receipt FundingReceipt, v []byte) error {
+	key := []byte(mkPKEY(receipt.Username, receipt.ChainPrefix))
+	b := tx.Bucket(receiptsBucket)
+	if old := b.Get(key); old != nil {
+		var previous FundingReceipt
+		if err := json.Unmarshal(old, &previous); err != nil {
+			return err
+		}
+		if err := unindexRecipient(tx, previous, key); err != nil {
+			return err
+		}
+	}
+	if err := b.Put(key, v); err != nil {
+		return err
+	}
+	return indexRecipient(tx, receipt, key)
+}
+
+func recipientKey(receipt FundingReceipt) []byte {
+	return []byte(mkPKEY(receipt.Recipient, receipt.ChainPrefix))
+}
+
+// recipientKeys returns the keys of the receipts funding the recipient of receipt on its chain
+func recipientKeys(tx *bolt.Tx, receipt FundingReceipt) ([]string, error) {
+	var keys []string
+	v := tx.Bucket(recipientsBucket).Get(recipientKey(receipt))
+	if v == nil {
+		return nil, nil
+	}
+	if err := json.Unmarshal(v, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func putRecipientKeys(tx *bolt.Tx, receipt FundingReceipt, keys []string) error {
+	b := tx.Bucket(recipientsBucket)
+	if len(keys) == 0 {
+		return b.Delete(recipientKey(receipt))
+	}
+	v, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return b.Put(recipientKey(receipt), v)
+}
+
+func indexRecipient(tx *bolt.Tx, receipt FundingReceipt, key []byte) error {
+	if receipt.Recipient == "" {
+		return nil
+	}
+	keys, err := recipientKeys(tx, receipt)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k == string(key) {
+			return nil
+		}
+	}
+	return putRecipientKeys(tx, receipt, append(keys, string(key)))
+}
+
+func unindexRecipient(tx *bolt.Tx, receipt FundingReceipt, key []byte) error {
+	if receipt.Recipient == "" {
+		return nil
+	}
+	keys, err := recipientKeys(tx, receipt)
+	if err != nil {
+		return err
+	}
+	kept := keys[:0]
+	for _, k := range keys {
+		if k != string(key) {
+			kept = append(kept, k)
+		}
+	}
+	return putRecipientKeys(tx, receipt, kept)
+}
+
+func (s *BoltReceiptStore) GetByUsernameAndChainPrefix(ctx context.Context, username Username, chainPrefix ChainPrefix) (*FundingReceipt, error) {
+	var receipt *FundingReceipt
+	err := s.bolt.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(receiptsBucket).Get([]byte(mkPKEY(username, chainPrefix)))
+		if v == nil {
+			return nil
+		}
+		receipt = &FundingReceipt{}
+		return json.Unmarshal(v, receipt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+func (s *BoltReceiptStore) Reserve(ctx context.Context, newReceipt FundingReceipt, notBefore time.Time) (*FundingReceipt, error) {
+	v, err := json.Marshal(newReceipt)
+	if err != nil {
+		return nil, err
+	}
+	key := mkPKEY(newReceipt.Username, newReceipt.ChainPrefix)
+
+	var blocking *FundingReceipt
+	err = s.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(receiptsBucket)
+		// the receipt of the user and the receipts funding the same recipient are the only ones which can block
+		keys, err := recipientKeys(tx, newReceipt)
+		if err != nil {
+			return err
+		}
+		for _, k := range append([]string{key}, keys...) {
+			existing := b.Get([]byte(k))
+			if existing == nil {
+				continue
+			}
+			var receipt FundingReceipt
+			if err := json.Unmarshal(existing, &receipt); err != nil {
+				return err
+			}
+			if receipt.Blocks(newReceipt, notBefore) {
+				blocking = &receipt
+				return nil
+			}
+		}
+		return putReceipt(tx, newReceipt, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blocking, nil
+}
+
+func (s *BoltReceiptStore) PruneExpired(ctx context.Context, beforeFundingTime time.Time) (int, error) {
+	var expired [][]byte
+	err := s.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(receiptsBucket)
+		var receipts []FundingReceipt
+		err := b.ForEach(func(k, v []byte) error {
+			var receipt FundingReceipt
+			if err := json.Unmarshal(v, &receipt); err != nil {
+				return err
+			}
+			if !receipt.FundedAt.After(beforeFundingTime) {
+				// keys are only valid for the life of the transaction
+				expired = append(expired, append([]byte{}, k...))
+				receipts = append(receipts, receipt)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// deleting while iterating with a cursor skips entries, so delete afterwards
+		for i, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			if err := unindexRecipient(tx, receipts[i], k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+func (s *BoltReceiptStore) List(ctx context.Context) (FundingReceipts, error) {
+	receipts := FundingReceipts{}
+	err := s.bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(receiptsBucket).ForEach(func(k, v []byte) error {
+			var receipt FundingReceipt
+			if err := json.Unmarshal(v, &receipt); err != nil {
+				return err
+			}
+			receipts = append(receipts, receipt)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+func (s *BoltReceiptStore) Close() error {
+	return s.bolt.Close()
+}
+
+func (s *BoltReceiptStore) Enqueue(ctx context.Context, req QueuedRequest) error {
+	v, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).Put([]byte(req.ID), v)
+	})
+}
+
+func (s *BoltReceiptStore) Dequeue(ctx context.Context, id string) error {
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).Delete([]byte(id))
+	})
+}
+
+func (s *BoltReceiptStore) ListQueued(ctx context.Context) ([]QueuedRequest, error) {
+	var reqs []QueuedRequest
+	err := s.bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).ForEach(func(k, v []byte) error {
+			var req QueuedRequest
+			if err := json.Unmarshal(v, &req); err != nil {
+				return err
+			}
+			reqs = append(reqs, req)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+func (s *BoltReceiptStore) Claim(ctx context.Context, id string, owner string, now time.Time, until time.Time) (*QueuedRequest, error) {
+	var claimed *QueuedRequest
+	err := s.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(queueBucket)
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		var req QueuedRequest
+		if err := json.Unmarshal(v, &req); err != nil {
+			return err
+		}
+		if !req.LeaseExpired(now) {
+			return nil
+		}
+		req.Owner, req.LeaseUntil = owner, until
+		v, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		claimed = &req
+		return b.Put([]byte(id), v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (s *BoltReceiptStore) RenewLeases(ctx context.Context, owner string, until time.Time) error {
+	return s.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(queueBucket)
+		renewed := map[string][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			var req QueuedRequest
+			if err := json.Unmarshal(v, &req); err != nil {
+				return err
+			}
+			if req.Owner != owner {
+				return nil
+			}
+			req.LeaseUntil = until
+			v, err := json.Marshal(req)
+			if err != nil {
+				return err
+			}
+			renewed[string(k)] = v
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// writing while iterating with ForEach isn't allowed
+		for k, v := range renewed {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
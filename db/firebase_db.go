@@ -5,7 +5,6 @@ import (
 	"crypto/md5"
 	"fmt"
 	"os"
-	"sync"
 	"time"
 
 	b64 "encoding/base64"
@@ -20,26 +19,72 @@ import (
 
 type ChainPrefix = string
 type Username = string
+type ReceiptStatus = string
+
+const (
+	// ReceiptPending is a reserved slot whose transaction has not been broadcast yet
+	ReceiptPending ReceiptStatus = "pending"
+	// ReceiptBroadcast is a reserved slot whose transaction was broadcast and is waiting to be committed.
+	// The request must not be sent again, its TxHash tells what became of it.
+	ReceiptBroadcast ReceiptStatus = "broadcast"
+	// ReceiptConfirmed is a receipt whose transaction was committed in a block
+	ReceiptConfirmed ReceiptStatus = "confirmed"
+	// ReceiptUnconfirmed is a receipt whose transaction was broadcast but not seen in a block in time.
+	// It may still be committed, so it counts against the cooldown.
+	ReceiptUnconfirmed ReceiptStatus = "unconfirmed"
+	// ReceiptFailed is a receipt whose transaction failed; it does not count against the cooldown
+	ReceiptFailed ReceiptStatus = "failed"
+)
+
 type FundingReceipt struct {
-	ChainPrefix ChainPrefix       `firestore:"chainPrefix"`
-	Username    Username          `firestore:"username"`
-	FundedAt    time.Time         `firestore:"fundedAt"`
-	Amount      cosmostypes.Coins `firestore:"amount"`
+	ChainPrefix ChainPrefix       `firestore:"chainPrefix" json:"chainPrefix"`
+	Username    Username          `firestore:"username" json:"username"`
+	Recipient   string            `firestore:"recipient" json:"recipient"`
+	FundedAt    time.Time         `firestore:"fundedAt" json:"fundedAt"`
+	Amount      cosmostypes.Coins `firestore:"amount" json:"amount"`
+	Status      ReceiptStatus     `firestore:"status" json:"status"`
+	TxHash      string            `firestore:"txHash" json:"txHash"`
 }
 type FundingReceipts []FundingReceipt
 
+// InCooldown tells if the receipt prevents funding again when the interval started at notBefore
+func (r FundingReceipt) InCooldown(notBefore time.Time) bool {
+	return r.Status != ReceiptFailed && r.FundedAt.After(notBefore)
+}
+
+// Blocks tells if the receipt prevents newReceipt, either because it is for the same user
+// or because it funded the same recipient on the same chain
+func (r FundingReceipt) Blocks(newReceipt FundingReceipt, notBefore time.Time) bool {
+	if r.ChainPrefix != newReceipt.ChainPrefix || !r.InCooldown(notBefore) {
+		return false
+	}
+	return r.Username == newReceipt.Username || (r.Recipient != "" && r.Recipient == newReceipt.Recipient)
+}
+
+// CooldownError is returned when a user asks for funding before their interval has passed
+type CooldownError struct {
+	Receipt FundingReceipt
+	Until   time.Time
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("%s was funded on %s at %s, next funding possible at %s", e.Receipt.Username, e.Receipt.ChainPrefix, e.Receipt.FundedAt, e.Until)
+}
+
 // Db represents the application interface for accessing the database
 type Db struct {
-	ctx      context.Context
-	receipts FundingReceipts
-	rw       sync.RWMutex
+	ctx   context.Context
+	store ReceiptStore
+	// owner identifies this process in the leases of the queued requests
+	owner string
 }
 
-func NewDb(ctx context.Context) *Db {
+func NewDb(ctx context.Context, store ReceiptStore) *Db {
 
 	return &Db{
-		ctx:      ctx,
-		receipts: FundingReceipts{},
+		ctx:   ctx,
+		store: store,
+		owner: randomID(),
 	}
 }
 
@@ -88,52 +133,100 @@ func initFirestore(ctx context.Context) (*firestore.Client, error) {
 
 func (db *Db) SaveFundingReceipt(ctx context.Context, newReceipt FundingReceipt) error {
 	// Safe receipt with timestamp etc
-
-	db.rw.Lock()
-	defer db.rw.Unlock()
-	db.receipts = append(db.receipts, newReceipt)
-	log.Infof("SAVED RECEIPT: %s %s(), %s", newReceipt.Username, newReceipt.ChainPrefix, newReceipt.FundedAt)
-	fmt.Println(db.receipts)
+	err := db.store.Save(ctx, newReceipt)
+	if err != nil {
+		return err
+	}
+	log.Infof("SAVED RECEIPT: %s %s(), %s %s %s", newReceipt.Username, newReceipt.ChainPrefix, newReceipt.FundedAt, newReceipt.Status, newReceipt.TxHash)
 
 	return nil
 }
 
 func (db *Db) PruneExpiredReceipts(ctx context.Context, beforeFundingTime time.Time) (int, error) {
+	// Delete stale receipts
+	return db.store.PruneExpired(ctx, beforeFundingTime)
+}
 
-	db.rw.Lock()
-	defer db.rw.Unlock()
+func (db *Db) GetFundingReceiptByUsernameAndChainPrefix(ctx context.Context, username string, chainPrefix string) (*FundingReceipt, error) {
+	// Get the receipts
+	receipt, err := db.store.GetByUsernameAndChainPrefix(ctx, username, chainPrefix)
+	if err != nil {
+		return nil, err
+	}
 
-	receipts := FundingReceipts{}
-	count := len(db.receipts)
+	if receipt == nil {
+		log.Infof("user: %s chain:%s was not found", username, chainPrefix)
+		return nil, nil
+	}
+	log.Infof("found: (%s)%s (%s)", chainPrefix, username, receipt.FundedAt)
+	return receipt, nil
+}
 
-	for _, v := range db.receipts {
-		if v.FundedAt.After(beforeFundingTime) {
-			receipts = append(receipts, v)
-			//db.receipts = append(db.receipts[:k], db.receipts[k+1:]...)
-		}
+// ReserveFundingSlot checks the funding interval and records a receipt for the user in one atomic step,
+// so concurrent requests from the same user cannot both pass the cooldown check. A *CooldownError is
+// returned when the user, or the recipient address regardless of who asked, was funded within the
+// interval. The returned receipt must later be passed to either FinalizeFundingSlot or FailFundingSlot.
+func (db *Db) ReserveFundingSlot(ctx context.Context, username Username, chainPrefix ChainPrefix, recipient string, amount cosmostypes.Coins, interval time.Duration) (*FundingReceipt, error) {
+	now := time.Now()
+	reservation := FundingReceipt{
+		ChainPrefix: chainPrefix,
+		Username:    username,
+		Recipient:   recipient,
+		FundedAt:    now,
+		Amount:      amount,
+		Status:      ReceiptPending,
 	}
+	blocking, err := db.store.Reserve(ctx, reservation, now.Add(-interval))
+	if err != nil {
+		return nil, err
+	}
+	if blocking != nil {
+		log.Infof("cooldown: (%s)%s %s blocked by %s %s funded at %s", chainPrefix, username, recipient, blocking.Username, blocking.Recipient, blocking.FundedAt)
+		return nil, &CooldownError{Receipt: *blocking, Until: blocking.FundedAt.Add(interval)}
+	}
+	log.Infof("RESERVED: %s %s(), %s", username, chainPrefix, now)
+	return &reservation, nil
+}
 
-	db.receipts = receipts
+// FinalizeFundingSlot records that the reserved funding was sent in txHash, starting the cooldown from now
+func (db *Db) FinalizeFundingSlot(ctx context.Context, reservation FundingReceipt, txHash string) error {
+	reservation.FundedAt = time.Now()
+	reservation.Status = ReceiptConfirmed
+	reservation.TxHash = txHash
+	return db.SaveFundingReceipt(ctx, reservation)
+}
 
-	// Delete stale receipts
-	return count - len(db.receipts), nil
+// BroadcastFundingSlot records that the reserved funding was broadcast in txHash, before waiting for it
+// to be committed, so a replay of the request looks the tx up instead of sending it again
+func (db *Db) BroadcastFundingSlot(ctx context.Context, reservation FundingReceipt, txHash string) error {
+	reservation.Status = ReceiptBroadcast
+	reservation.TxHash = txHash
+	return db.SaveFundingReceipt(ctx, reservation)
 }
 
-func (db *Db) GetFundingReceiptByUsernameAndChainPrefix(ctx context.Context, username string, chainPrefix string) (*FundingReceipt, error) {
-	// Get the receipts
+// UnconfirmFundingSlot records that the reserved funding was broadcast in txHash but not confirmed.
+// The cooldown starts from now as the funding may still arrive.
+func (db *Db) UnconfirmFundingSlot(ctx context.Context, reservation FundingReceipt, txHash string) error {
+	reservation.FundedAt = time.Now()
+	reservation.Status = ReceiptUnconfirmed
+	reservation.TxHash = txHash
+	return db.SaveFundingReceipt(ctx, reservation)
+}
 
-	db.rw.RLock()
-	defer db.rw.RUnlock()
+// FailFundingSlot records that the reserved funding could not be sent, so the user may retry.
+// txHash is empty when the transaction never made it to the chain.
+func (db *Db) FailFundingSlot(ctx context.Context, reservation FundingReceipt, txHash string) error {
+	reservation.Status = ReceiptFailed
+	reservation.TxHash = txHash
+	return db.SaveFundingReceipt(ctx, reservation)
+}
 
-	for k, v := range db.receipts {
-		if v.ChainPrefix == chainPrefix && v.Username == username {
-			log.Infof("found: (%s)%s (%s)", chainPrefix, username, v.FundedAt)
-			return &db.receipts[k], nil
-		}
-	}
+func (db *Db) ListFundingReceipts(ctx context.Context) (FundingReceipts, error) {
+	return db.store.List(ctx)
+}
 
-	log.Infof("user: %s chain:%s was not found", username, chainPrefix)
-	return nil, nil
+func (db *Db) Close() error {
+	return db.store.Close()
 }
 
 func mkPKEY(username string, chainPrefix string) string {
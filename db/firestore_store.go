@@ -0,0 +1,331 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// firestore limits a single write batch to 500 operations
+const maxFirestoreBatch = 500
+
+// firestoreReceipt is the document stored in firestore. Coins are kept in their
+// string form since sdk.Int has no exported fields for firestore to encode.
+type firestoreReceipt struct {
+	ChainPrefix ChainPrefix `firestore:"chainPrefix"`
+	Username    Username    `firestore:"username"`
+	Recipient   string      `firestore:"recipient"`
+	FundedAt    time.Time   `firestore:"fundedAt"`
+	Amount      string      `firestore:"amount"`
+	Status      string      `firestore:"status"`
+	TxHash      string      `firestore:"txHash"`
+	// ExpireAt lets a firestore TTL policy delete the document server-side
+	ExpireAt time.Time `firestore:"expireAt"`
+}
+
+func (r firestoreReceipt) receipt() (*FundingReceipt, error) {
+	amount, err := cosmostypes.ParseCoinsNormalized(r.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return &FundingReceipt{
+		ChainPrefix: r.ChainPrefix,
+		Username:    r.Username,
+		Recipient:   r.Recipient,
+		FundedAt:    r.FundedAt,
+		Amount:      amount,
+		Status:      r.Status,
+		TxHash:      r.TxHash,
+	}, nil
+}
+
+// FirestoreReceiptStore keeps receipts in a firestore collection, one document per
+// user and chain, so several bot replicas can share the same cooldown state.
+type FirestoreReceiptStore struct {
+	client     *firestore.Client
+	collection *firestore.CollectionRef
+	// queue holds the queued requests, in a collection named after the receipts one
+	queue *firestore.CollectionRef
+	ttl   time.Duration
+}
+
+// firestoreQueuedRequest is a queued request document. The request is kept as JSON,
+// like the coins of receipts firestore can't encode it. The lease has its own fields so
+// it can be queried and renewed, they override the ones in Data.
+type firestoreQueuedRequest struct {
+	Data       string    `firestore:"data"`
+	QueuedAt   time.Time `firestore:"queuedAt"`
+	Owner      string    `firestore:"owner"`
+	LeaseUntil time.Time `firestore:"leaseUntil"`
+}
+
+func (doc firestoreQueuedRequest) request() (QueuedRequest, error) {
+	var req QueuedRequest
+	if err := json.Unmarshal([]byte(doc.Data), &req); err != nil {
+		return req, err
+	}
+	req.Owner, req.LeaseUntil = doc.Owner, doc.LeaseUntil
+	return req, nil
+}
+
+// NewFirestoreReceiptStore connects to firestore (or the emulator when FIRESTORE_EMULATOR_HOST is set).
+// ttl is added to the funding time to fill the expireAt field used by the TTL policy.
+func NewFirestoreReceiptStore(ctx context.Context, collection string, ttl time.Duration) (*FirestoreReceiptStore, error) {
+	client, err := initFirestore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &FirestoreReceiptStore{
+		client:     client,
+		collection: client.Collection(collection),
+		queue:      client.Collection(collection + "_queue"),
+		ttl:        ttl,
+	}, nil
+}
+
+func (s *FirestoreReceiptStore) doc(username Username, chainPrefix ChainPrefix) *firestore.DocumentRef {
+	return s.collection.Doc(mkPKEY(username, chainPrefix))
+}
+
+// getReceipt reads a receipt inside a transaction, returning nil if the document does not exist
+func (s *FirestoreReceiptStore) getReceipt(tx *firestore.Transaction, ref *firestore.DocumentRef) (*FundingReceipt, error) {
+	snap, err := tx.Get(ref)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc firestoreReceipt
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, err
+	}
+	return doc.receipt()
+}
+
+func (s *FirestoreReceiptStore) toDoc(receipt FundingReceipt) firestoreReceipt {
+	return firestoreReceipt{
+		ChainPrefix: receipt.ChainPrefix,
+		Username:    receipt.Username,
+		Recipient:   receipt.Recipient,
+		FundedAt:    receipt.FundedAt,
+		Amount:      receipt.Amount.String(),
+		Status:      receipt.Status,
+		TxHash:      receipt.TxHash,
+		ExpireAt:    receipt.FundedAt.Add(s.ttl),
+	}
+}
+
+func (s *FirestoreReceiptStore) Save(ctx context.Context, newReceipt FundingReceipt) error {
+	ref := s.doc(newReceipt.Username, newReceipt.ChainPrefix)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing, err := s.getReceipt(tx, ref)
+		if err != nil {
+			return err
+		}
+		// another replica may have recorded a more recent funding in the meantime
+		if existing != nil && existing.FundedAt.After(newReceipt.FundedAt) {
+			return nil
+		}
+		return tx.Set(ref, s.toDoc(newReceipt))
+	})
+}
+
+func (s *FirestoreReceiptStore) Reserve(ctx context.Context, newReceipt FundingReceipt, notBefore time.Time) (*FundingReceipt, error) {
+	ref := s.doc(newReceipt.Username, newReceipt.ChainPrefix)
+	var blocking *FundingReceipt
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// the function may be retried on contention, so reset the result each attempt
+		blocking = nil
+		existing, err := s.getReceipt(tx, ref)
+		if err != nil {
+			return err
+		}
+		if existing != nil && existing.InCooldown(notBefore) {
+			blocking = existing
+			return nil
+		}
+		if newReceipt.Recipient != "" {
+			// the same address may have been funded by another user
+			q := s.collection.Where("chainPrefix", "==", newReceipt.ChainPrefix).Where("recipient", "==", newReceipt.Recipient)
+			snaps, err := tx.Documents(q).GetAll()
+			if err != nil {
+				return err
+			}
+			for _, snap := range snaps {
+				var doc firestoreReceipt
+				if err := snap.DataTo(&doc); err != nil {
+					return err
+				}
+				receipt, err := doc.receipt()
+				if err != nil {
+					return err
+				}
+				if receipt.Blocks(newReceipt, notBefore) {
+					blocking = receipt
+					return nil
+				}
+			}
+		}
+		return tx.Set(ref, s.toDoc(newReceipt))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blocking, nil
+}
+
+func (s *FirestoreReceiptStore) GetByUsernameAndChainPrefix(ctx context.Context, username Username, chainPrefix ChainPrefix) (*FundingReceipt, error) {
+	snap, err := s.doc(username, chainPrefix).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc firestoreReceipt
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, err
+	}
+	return doc.receipt()
+}
+
+func (s *FirestoreReceiptStore) PruneExpired(ctx context.Context, beforeFundingTime time.Time) (int, error) {
+	refs, err := s.collection.Where("fundedAt", "<=", beforeFundingTime).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for len(refs) > 0 {
+		n := len(refs)
+		if n > maxFirestoreBatch {
+			n = maxFirestoreBatch
+		}
+		batch := s.client.Batch()
+		for _, snap := range refs[:n] {
+			batch.Delete(snap.Ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return count, err
+		}
+		count += n
+		refs = refs[n:]
+	}
+	return count, nil
+}
+
+func (s *FirestoreReceiptStore) List(ctx context.Context) (FundingReceipts, error) {
+	snaps, err := s.collection.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	receipts := make(FundingReceipts, 0, len(snaps))
+	for _, snap := range snaps {
+		var doc firestoreReceipt
+		if err := snap.DataTo(&doc); err != nil {
+			return nil, err
+		}
+		receipt, err := doc.receipt()
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, *receipt)
+	}
+	return receipts, nil
+}
+
+func (s *FirestoreReceiptStore) Enqueue(ctx context.Context, req QueuedRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = s.queue.Doc(req.ID).Set(ctx, firestoreQueuedRequest{Data: string(data), QueuedAt: req.QueuedAt, Owner: req.Owner, LeaseUntil: req.LeaseUntil})
+	return err
+}
+
+func (s *FirestoreReceiptStore) Dequeue(ctx context.Context, id string) error {
+	_, err := s.queue.Doc(id).Delete(ctx)
+	return err
+}
+
+func (s *FirestoreReceiptStore) ListQueued(ctx context.Context) ([]QueuedRequest, error) {
+	snaps, err := s.queue.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	reqs := make([]QueuedRequest, 0, len(snaps))
+	for _, snap := range snaps {
+		var doc firestoreQueuedRequest
+		if err := snap.DataTo(&doc); err != nil {
+			return nil, err
+		}
+		req, err := doc.request()
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+func (s *FirestoreReceiptStore) Claim(ctx context.Context, id string, owner string, now time.Time, until time.Time) (*QueuedRequest, error) {
+	ref := s.queue.Doc(id)
+	var claimed *QueuedRequest
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// the function may be retried on contention, so reset the result each attempt
+		claimed = nil
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var doc firestoreQueuedRequest
+		if err := snap.DataTo(&doc); err != nil {
+			return err
+		}
+		req, err := doc.request()
+		if err != nil {
+			return err
+		}
+		if !req.LeaseExpired(now) {
+			return nil
+		}
+		req.Owner, req.LeaseUntil = owner, until
+		claimed = &req
+		return tx.Update(ref, []firestore.Update{
+			{Path: "owner", Value: owner},
+			{Path: "leaseUntil", Value: until},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (s *FirestoreReceiptStore) RenewLeases(ctx context.Context, owner string, until time.Time) error {
+	snaps, err := s.queue.Where("owner", "==", owner).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	// one by one, a batch would fail as a whole for a request completed since the query
+	for _, snap := range snaps {
+		_, err := snap.Ref.Update(ctx, []firestore.Update{{Path: "leaseUntil", Value: until}}, firestore.LastUpdateTime(snap.UpdateTime))
+		if err != nil && status.Code(err) != codes.NotFound && status.Code(err) != codes.FailedPrecondition {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FirestoreReceiptStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryReceiptStore keeps receipts in process memory. Receipts and queued requests are lost on restart.
+type MemoryReceiptStore struct {
+	receipts FundingReceipts
+	queue    map[string]QueuedRequest
+	rw       sync.RWMutex
+}
+
+func NewMemoryReceiptStore() *MemoryReceiptStore {
+	return &MemoryReceiptStore{
+		receipts: FundingReceipts{},
+		queue:    map[string]QueuedRequest{},
+	}
+}
+
+func (s *MemoryReceiptStore) Save(ctx context.Context, newReceipt FundingReceipt) error {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	s.save(newReceipt)
+	return nil
+}
+
+// save must be called with the write lock held
+func (s *MemoryReceiptStore) save(newReceipt FundingReceipt) {
+	for k, v := range s.receipts {
+		if v.ChainPrefix == newReceipt.ChainPrefix && v.Username == newReceipt.Username {
+			s.receipts[k] = newReceipt
+			return
+		}
+	}
+	s.receipts = append(s.receipts, newReceipt)
+}
+
+func (s *MemoryReceiptStore) Reserve(ctx context.Context, newReceipt FundingReceipt, notBefore time.Time) (*FundingReceipt, error) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	for _, v := range s.receipts {
+		if v.Blocks(newReceipt, notBefore) {
+			receipt := v
+			return &receipt, nil
+		}
+	}
+	s.save(newReceipt)
+	return nil, nil
+}
+
+func (s *MemoryReceiptStore) GetByUsernameAndChainPrefix(ctx context.Context, username Username, chainPrefix ChainPrefix) (*FundingReceipt, error) {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
+	for _, v := range s.receipts {
+		if v.ChainPrefix == chainPrefix && v.Username == username {
+			receipt := v
+			return &receipt, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryReceiptStore) PruneExpired(ctx context.Context, beforeFundingTime time.Time) (int, error) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	receipts := FundingReceipts{}
+	count := len(s.receipts)
+
+	for _, v := range s.receipts {
+		if v.FundedAt.After(beforeFundingTime) {
+			receipts = append(receipts, v)
+		}
+	}
+
+	s.receipts = receipts
+	return count - len(s.receipts), nil
+}
+
+func (s *MemoryReceiptStore) List(ctx context.Context) (FundingReceipts, error) {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
+	receipts := make(FundingReceipts, len(s.receipts))
+	copy(receipts, s.receipts)
+	return receipts, nil
+}
+
+func (s *MemoryReceiptStore) Enqueue(ctx context.Context, req QueuedRequest) error {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	s.queue[req.ID] = req
+	return nil
+}
+
+func (s *MemoryReceiptStore) Dequeue(ctx context.Context, id string) error {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	delete(s.queue, id)
+	return nil
+}
+
+func (s *MemoryReceiptStore) ListQueued(ctx context.Context) ([]QueuedRequest, error) {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+
+	reqs := make([]QueuedRequest, 0, len(s.queue))
+	for _, req := range s.queue {
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+func (s *MemoryReceiptStore) Claim(ctx context.Context, id string, owner string, now time.Time, until time.Time) (*QueuedRequest, error) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	req, ok := s.queue[id]
+	if !ok || !req.LeaseExpired(now) {
+		return nil, nil
+	}
+	req.Owner, req.LeaseUntil = owner, until
+	s.queue[id] = req
+	return &req, nil
+}
+
+func (s *MemoryReceiptStore) RenewLeases(ctx context.Context, owner string, until time.Time) error {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	for id, req := range s.queue {
+		if req.Owner == owner {
+			req.LeaseUntil = until
+			s.queue[id] = req
+		}
+	}
+	return nil
+}
+
+func (s *MemoryReceiptStore) Close() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplyRef tells the frontend that accepted a request where to report its outcome, even after a restart
+type ReplyRef struct {
+	Frontend  string `json:"frontend"`
+	ChannelID string `json:"channelId,omitempty"`
+	MessageID string `json:"messageId,omitempty"`
+	UserID    string `json:"userId,omitempty"`
+	IsDM      bool   `json:"isDM,omitempty"`
+	// GuildID is the Discord server of the channel
+	GuildID string `json:"guildId,omitempty"`
+}
+
+// QueuedRequest is a funding request accepted by a frontend which the faucet worker did not process yet
+type QueuedRequest struct {
+	ID string `json:"id"`
+	// Receipt is the reserved funding slot, holding the requester ID, recipient and amount
+	Receipt       FundingReceipt    `json:"receipt"`
+	Fees          cosmostypes.Coins `json:"fees"`
+	RequesterName string            `json:"requesterName"`
+	Reply         ReplyRef          `json:"reply"`
+	QueuedAt      time.Time         `json:"queuedAt"`
+	// Owner is the process sending the request for as long as it renews LeaseUntil
+	Owner      string    `json:"owner"`
+	LeaseUntil time.Time `json:"leaseUntil"`
+}
+
+// QueueLease is how long a queued request stays with its process without a renewal. Other
+// processes sharing the store replay it once the lease expired.
+const QueueLease = 30 * time.Second
+
+// LeaseExpired tells if the process holding the request stopped renewing its lease
+func (req QueuedRequest) LeaseExpired(now time.Time) bool {
+	return !req.LeaseUntil.After(now)
+}
+
+func randomID() string {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// QueueRequest durably records an accepted request, leased to this process, and returns its queue ID
+func (db *Db) QueueRequest(ctx context.Context, req QueuedRequest) (string, error) {
+	req.ID = randomID()
+	req.QueuedAt = time.Now()
+	req.Owner = db.owner
+	req.LeaseUntil = req.QueuedAt.Add(QueueLease)
+	if err := db.store.Enqueue(ctx, req); err != nil {
+		return "", err
+	}
+	log.Infof("QUEUED: %s %s(), %s", req.Receipt.Username, req.Receipt.ChainPrefix, req.ID)
+	return req.ID, nil
+}
+
+// CompleteQueuedRequest removes a processed request from the queue
+func (db *Db) CompleteQueuedRequest(ctx context.Context, id string) error {
+	return db.store.Dequeue(ctx, id)
+}
+
+// AbandonedRequests returns the queued requests whose lease expired, oldest first.
+// They have to be claimed before they are sent again.
+func (db *Db) AbandonedRequests(ctx context.Context) ([]QueuedRequest, error) {
+	queued, err := db.store.ListQueued(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var reqs []QueuedRequest
+	for _, req := range queued {
+		if req.LeaseExpired(now) {
+			reqs = append(reqs, req)
+		}
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		return reqs[i].QueuedAt.Before(reqs[j].QueuedAt)
+	})
+	return reqs, nil
+}
+
+// ClaimQueuedRequest takes over a queued request whose lease expired. It returns nil when the
+// request is gone or another process claimed it first.
+func (db *Db) ClaimQueuedRequest(ctx context.Context, id string) (*QueuedRequest, error) {
+	now := time.Now()
+	return db.store.Claim(ctx, id, db.owner, now, now.Add(QueueLease))
+}
+
+// RenewQueueLeases extends the leases of the requests held by this process
+func (db *Db) RenewQueueLeases(ctx context.Context) error {
+	return db.store.RenewLeases(ctx, db.owner, time.Now().Add(QueueLease))
+}
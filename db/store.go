@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReceiptStore is the storage backend used by Db to persist funding receipts
+type ReceiptStore interface {
+	// Save stores a receipt, replacing any previous receipt for the same username and chain prefix
+	Save(ctx context.Context, receipt FundingReceipt) error
+	// GetByUsernameAndChainPrefix returns the latest receipt for a user on a chain, or nil if there is none
+	GetByUsernameAndChainPrefix(ctx context.Context, username Username, chainPrefix ChainPrefix) (*FundingReceipt, error)
+	// Reserve atomically saves newReceipt unless a receipt for the same chain and either the same user or
+	// the same recipient is still in cooldown after notBefore. In that case nothing is written and the
+	// blocking receipt is returned.
+	Reserve(ctx context.Context, newReceipt FundingReceipt, notBefore time.Time) (*FundingReceipt, error)
+	// PruneExpired deletes all receipts funded before the given time and returns how many were removed
+	PruneExpired(ctx context.Context, beforeFundingTime time.Time) (int, error)
+	// List returns every stored receipt
+	List(ctx context.Context) (FundingReceipts, error)
+	// Enqueue stores a request accepted by a frontend until Dequeue is called with its ID
+	Enqueue(ctx context.Context, req QueuedRequest) error
+	// Dequeue removes a queued request, if it is still there
+	Dequeue(ctx context.Context, id string) error
+	// ListQueued returns every queued request
+	ListQueued(ctx context.Context) ([]QueuedRequest, error)
+	// Claim atomically leases a queued request to owner until the given time, unless its lease is
+	// still valid at now. The claimed request is returned, or nil when it wasn't claimed.
+	Claim(ctx context.Context, id string, owner string, now time.Time, until time.Time) (*QueuedRequest, error)
+	// RenewLeases extends the leases of every queued request held by owner
+	RenewLeases(ctx context.Context, owner string, until time.Time) error
+	// Close releases any resources held by the store
+	Close() error
+}
+
+const (
+	BackendMemory    = "memory"
+	BackendBolt      = "bolt"
+	BackendFirestore = "firestore"
+)
+
+// StoreConfig selects and configures a ReceiptStore
+type StoreConfig struct {
+	Backend string
+	// Path is the file used by the bolt backend
+	Path string
+	// Collection is the collection used by the firestore backend
+	Collection string
+	// TTL is how long a receipt stays relevant, used by backends with server-side expiry
+	TTL time.Duration
+}
+
+// NewReceiptStore creates the receipt store for the configured backend
+func NewReceiptStore(ctx context.Context, conf StoreConfig) (ReceiptStore, error) {
+	switch conf.Backend {
+	case "", BackendMemory:
+		return NewMemoryReceiptStore(), nil
+	case BackendBolt:
+		return NewBoltReceiptStore(conf.Path)
+	case BackendFirestore:
+		return NewFirestoreReceiptStore(ctx, conf.Collection, conf.TTL)
+	default:
+		return nil, fmt.Errorf("unknown receipt store backend %q", conf.Backend)
+	}
+}
@@ -0,0 +1,320 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stores returns a fresh store of every local backend, and of firestore when FIRESTORE_EMULATOR_HOST
+// points at an emulator
+func stores(t *testing.T) map[string]ReceiptStore {
+	bolt, err := NewBoltReceiptStore(filepath.Join(t.TempDir(), "fonzie.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	s := map[string]ReceiptStore{
+		BackendMemory: NewMemoryReceiptStore(),
+		BackendBolt:   bolt,
+	}
+
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		return s
+	}
+	if os.Getenv("GCP_PROJECT") == "" {
+		t.Setenv("GCP_PROJECT", "fonzie-test")
+	}
+	// the emulator keeps the documents of earlier runs, each test gets its own collections
+	collection := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+	firestore, err := NewFirestoreReceiptStore(context.Background(), collection, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { firestore.Close() })
+	s[BackendFirestore] = firestore
+	return s
+}
+
+func receipt(user, recipient string, fundedAt time.Time, status ReceiptStatus) FundingReceipt {
+	return FundingReceipt{
+		ChainPrefix: "umee",
+		Username:    user,
+		Recipient:   recipient,
+		FundedAt:    fundedAt,
+		Status:      status,
+	}
+}
+
+func TestSaveReplacesReceipt(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if got, err := s.GetByUsernameAndChainPrefix(ctx, "alice", "umee"); err != nil || got != nil {
+				t.Fatalf("got %v, %v before saving", got, err)
+			}
+			for _, r := range []FundingReceipt{
+				receipt("alice", "umee1a", now.Add(-time.Hour), ReceiptConfirmed),
+				receipt("alice", "umee1b", now, ReceiptConfirmed),
+			} {
+				if err := s.Save(ctx, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := s.GetByUsernameAndChainPrefix(ctx, "alice", "umee")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || got.Recipient != "umee1b" || !got.FundedAt.Equal(now) {
+				t.Errorf("got %v, want the receipt for umee1b", got)
+			}
+			receipts, err := s.List(ctx)
+			if err != nil || len(receipts) != 1 {
+				t.Errorf("listed %v, %v", receipts, err)
+			}
+		})
+	}
+}
+
+func TestPruneExpired(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, r := range []FundingReceipt{
+				receipt("alice", "umee1a", now.Add(-3*time.Hour), ReceiptConfirmed),
+				receipt("bob", "umee1b", now.Add(-2*time.Hour), ReceiptFailed),
+				receipt("carol", "umee1c", now, ReceiptConfirmed),
+			} {
+				if err := s.Save(ctx, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := s.PruneExpired(ctx, now.Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 2 {
+				t.Errorf("pruned %d receipts, want 2", n)
+			}
+			receipts, err := s.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(receipts) != 1 || receipts[0].Username != "carol" {
+				t.Errorf("kept %v, want carol's receipt", receipts)
+			}
+			if n, err := s.PruneExpired(ctx, now.Add(-time.Hour)); err != nil || n != 0 {
+				t.Errorf("pruned %d receipts again, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestReserveCooldown(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	notBefore := now.Add(-time.Hour)
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if blocking, err := s.Reserve(ctx, receipt("alice", "umee1a", now, ReceiptPending), notBefore); err != nil || blocking != nil {
+				t.Fatalf("first reservation: %v, %v", blocking, err)
+			}
+
+			cases := []struct {
+				name    string
+				receipt FundingReceipt
+				blocked bool
+			}{
+				{"same user", receipt("alice", "umee1b", now, ReceiptPending), true},
+				{"other chain", FundingReceipt{ChainPrefix: "juno", Username: "alice", Recipient: "juno1a", FundedAt: now}, false},
+				{"other user", receipt("carol", "umee1c", now, ReceiptPending), false},
+			}
+			for _, c := range cases {
+				blocking, err := s.Reserve(ctx, c.receipt, notBefore)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (blocking != nil) != c.blocked {
+					t.Errorf("%s: blocked by %v, want blocked %v", c.name, blocking, c.blocked)
+				}
+			}
+
+			// a blocked reservation writes nothing
+			got, err := s.GetByUsernameAndChainPrefix(ctx, "alice", "umee")
+			if err != nil || got == nil || got.Recipient != "umee1a" {
+				t.Errorf("alice has receipt %v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestReserveAfterCooldownOrFailure(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	notBefore := now.Add(-time.Hour)
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.Save(ctx, receipt("alice", "umee1a", now.Add(-2*time.Hour), ReceiptConfirmed)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Save(ctx, receipt("bob", "umee1b", now, ReceiptFailed)); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range []FundingReceipt{
+				receipt("alice", "umee1a", now, ReceiptPending),
+				receipt("bob", "umee1d", now, ReceiptPending),
+			} {
+				blocking, err := s.Reserve(ctx, r, notBefore)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if blocking != nil {
+					t.Errorf("%s blocked by %v", r.Username, blocking)
+				}
+			}
+		})
+	}
+}
+
+func TestReserveFundingSlot(t *testing.T) {
+	ctx := context.Background()
+	db := NewDb(ctx, NewMemoryReceiptStore())
+	reservation, err := db.ReserveFundingSlot(ctx, "alice", "umee", "umee1a", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ReserveFundingSlot(ctx, "alice", "umee", "umee1b", nil, time.Hour)
+	cooldown, ok := err.(*CooldownError)
+	if !ok {
+		t.Fatalf("got %v, want a *CooldownError", err)
+	}
+	if want := reservation.FundedAt.Add(time.Hour); !cooldown.Until.Equal(want) {
+		t.Errorf("cooldown until %s, want %s", cooldown.Until, want)
+	}
+
+	// a failed slot lifts the cooldown
+	if err := db.FailFundingSlot(ctx, *reservation, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ReserveFundingSlot(ctx, "alice", "umee", "umee1b", nil, time.Hour); err != nil {
+		t.Errorf("alice after failure: %v", err)
+	}
+}
+
+func TestReserveRecipient(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	notBefore := now.Add(-time.Hour)
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, r := range []FundingReceipt{
+				receipt("alice", "umee1a", now, ReceiptConfirmed),
+				receipt("bob", "umee1b", now, ReceiptFailed),
+				receipt("carol", "umee1c", now.Add(-2*time.Hour), ReceiptConfirmed),
+				// dave's receipt for umee1d is replaced by one for umee1e
+				receipt("dave", "umee1d", now, ReceiptConfirmed),
+				receipt("dave", "umee1e", now, ReceiptFailed),
+			} {
+				if err := s.Save(ctx, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cases := []struct {
+				recipient string
+				blocked   bool
+			}{
+				{"umee1a", true},
+				{"umee1b", false},
+				{"umee1c", false},
+				{"umee1d", false},
+			}
+			for _, c := range cases {
+				blocking, err := s.Reserve(ctx, receipt("eve"+c.recipient, c.recipient, now, ReceiptPending), notBefore)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (blocking != nil) != c.blocked {
+					t.Errorf("%s: blocked by %v, want blocked %v", c.recipient, blocking, c.blocked)
+				}
+				if blocking != nil && blocking.Username != "alice" {
+					t.Errorf("%s: blocked by %s, want alice", c.recipient, blocking.Username)
+				}
+			}
+		})
+	}
+}
+
+func TestReservePrunedRecipient(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.Save(ctx, receipt("alice", "umee1a", now.Add(-3*time.Hour), ReceiptConfirmed)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.PruneExpired(ctx, now.Add(-time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			// pruned receipts don't block, even with an interval reaching back to them
+			blocking, err := s.Reserve(ctx, receipt("bob", "umee1a", now, ReceiptPending), now.Add(-24*time.Hour))
+			if err != nil || blocking != nil {
+				t.Errorf("bob for umee1a blocked by %v, %v", blocking, err)
+			}
+		})
+	}
+}
+
+func TestClaimAndRenewLeases(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			leased := QueuedRequest{ID: "leased", Owner: "a", LeaseUntil: now.Add(QueueLease)}
+			expired := QueuedRequest{ID: "expired", Owner: "a", LeaseUntil: now.Add(-time.Second)}
+			for _, req := range []QueuedRequest{leased, expired} {
+				if err := s.Enqueue(ctx, req); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if req, err := s.Claim(ctx, "leased", "b", now, now.Add(QueueLease)); err != nil || req != nil {
+				t.Errorf("claimed a valid lease: %v, %v", req, err)
+			}
+			if req, err := s.Claim(ctx, "gone", "b", now, now.Add(QueueLease)); err != nil || req != nil {
+				t.Errorf("claimed a missing request: %v, %v", req, err)
+			}
+			req, err := s.Claim(ctx, "expired", "b", now, now.Add(QueueLease))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req == nil || req.Owner != "b" {
+				t.Fatalf("claimed %v, want the expired request owned by b", req)
+			}
+			if req, err := s.Claim(ctx, "expired", "c", now, now.Add(QueueLease)); err != nil || req != nil {
+				t.Errorf("claimed a request twice: %v, %v", req, err)
+			}
+
+			until := now.Add(2 * QueueLease)
+			if err := s.RenewLeases(ctx, "a", until); err != nil {
+				t.Fatal(err)
+			}
+			queued, err := s.ListQueued(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, req := range queued {
+				renewed := req.LeaseUntil.Equal(until)
+				if renewed != (req.Owner == "a") {
+					t.Errorf("%s owned by %s leased until %s", req.ID, req.Owner, req.LeaseUntil)
+				}
+			}
+		})
+	}
+}
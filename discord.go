@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Entrio/subenv"
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/umee-network/fonzie/chain"
+	"github.com/umee-network/fonzie/db"
+)
+
+// discordCmd is a command received from Discord, either as a `!` prefixed message or as a slash command.
+// Exactly one of msg and interaction is set.
+type discordCmd struct {
+	session     *discordgo.Session
+	msg         *discordgo.MessageCreate
+	interaction *discordgo.InteractionCreate
+}
+
+func (c discordCmd) author() *discordgo.User {
+	if c.msg != nil {
+		return c.msg.Author
+	}
+	if c.interaction.Member != nil {
+		return c.interaction.Member.User
+	}
+	return c.interaction.User
+}
+
+func (c discordCmd) guildID() string {
+	if c.msg != nil {
+		return c.msg.GuildID
+	}
+	return c.interaction.GuildID
+}
+
+// member returns the guild member who sent the command, which may be nil for messages
+func (c discordCmd) member() *discordgo.Member {
+	if c.msg != nil {
+		return c.msg.Member
+	}
+	return c.interaction.Member
+}
+
+func (c discordCmd) isDM() bool {
+	return c.guildID() == ""
+}
+
+func (c discordCmd) isBot() bool {
+	return c.author().Bot
+}
+
+// chat wraps the command for the platform independent chat helpers. Messages are answered in
+// their channel, slash commands through their interaction.
+func (c discordCmd) chat() chatCmd {
+	var client ChatClient = discordChat{c.session}
+	msg := ChatMessage{
+		UserID: c.author().ID,
+		IsDM:   c.isDM(),
+		IsBot:  c.isBot(),
+	}
+	if c.msg != nil {
+		msg.ID, msg.ChannelID, msg.Text = c.msg.ID, c.msg.ChannelID, c.msg.Content
+	} else {
+		client = discordInteraction{c.session, c.interaction}
+		msg.ChannelID = c.interaction.ChannelID
+	}
+	return chatCmd{
+		client:    client,
+		platform:  "discord",
+		msg:       msg,
+		requester: c.requester(),
+	}
+}
+
+// reply answers the command in place: a reply to the message, or the (ephemeral) interaction response
+func (c discordCmd) reply(msg string) error {
+	return c.chat().reply(msg)
+}
+
+func (c discordCmd) reportError(errToReport error) {
+	c.chat().reportError(errToReport)
+}
+
+// sendMessage privately messages the author: a DM for messages, an ephemeral follow-up for slash commands
+func (c discordCmd) sendMessage(msg string) error {
+	return c.chat().sendMessage(msg)
+}
+
+// sendReaction reacts to the message. Slash commands have no message to react to.
+func (c discordCmd) sendReaction(reaction string) error {
+	return c.chat().sendReaction(reaction)
+}
+
+func (c discordCmd) removedReaction(reaction string) error {
+	return c.chat().removedReaction(reaction)
+}
+
+// requester identifies a Discord user by their plain user ID
+func (c discordCmd) requester() Requester {
+	return Requester{ID: c.author().ID, Name: c.author().String()}
+}
+
+// Funded, Unconfirmed, Merged and Failed answer like on every chat platform
+func (c discordCmd) Funded(r FaucetReq, tx chain.TxResult) {
+	c.chat().Funded(r, tx)
+}
+
+func (c discordCmd) Unconfirmed(r FaucetReq, txHash string) {
+	c.chat().Unconfirmed(r, txHash)
+}
+
+func (c discordCmd) Merged(r FaucetReq, recipient string) {
+	c.chat().Merged(r, recipient)
+}
+
+func (c discordCmd) Failed(r FaucetReq, err error) {
+	c.chat().Failed(r, err)
+}
+
+// ReplyRef points at the message, or for slash commands the channel, the command came from.
+// Interaction tokens expire, so after a restart slash commands are answered in the channel.
+func (c discordCmd) ReplyRef() db.ReplyRef {
+	ref := db.ReplyRef{
+		Frontend: "discord",
+		UserID:   c.author().ID,
+		IsDM:     c.isDM(),
+		GuildID:  c.guildID(),
+	}
+	if c.msg != nil {
+		ref.ChannelID = c.msg.ChannelID
+		ref.MessageID = c.msg.ID
+	} else {
+		ref.ChannelID = c.interaction.ChannelID
+	}
+	return ref
+}
+
+// discordResponder answers a request replayed from the queue where it was made
+func discordResponder(s *discordgo.Session) ResponderFactory {
+	return func(ref db.ReplyRef, name string) Responder {
+		return discordCmd{session: s, msg: &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID:        ref.MessageID,
+			ChannelID: ref.ChannelID,
+			GuildID:   ref.GuildID,
+			Author:    &discordgo.User{ID: ref.UserID, Username: name},
+		}}}
+	}
+}
+
+// finderURL is the explorer URL to which transaction hashes are appended
+func finderURL() string {
+	return subenv.Env("FINDER_URL", "https://ping.wildsage.io/andromeda/tx")
+}
+
+// discordChat is the ChatClient for Discord messages
+type discordChat struct {
+	session *discordgo.Session
+}
+
+func (d discordChat) Reply(m ChatMessage, text string) error {
+	if m.ID == "" {
+		// a replayed slash command has no message to reply to
+		_, err := d.session.ChannelMessageSend(m.ChannelID, text)
+		return err
+	}
+	_, err := d.session.ChannelMessageSendReply(m.ChannelID, text, &discordgo.MessageReference{MessageID: m.ID, ChannelID: m.ChannelID})
+	return err
+}
+
+func (d discordChat) AddReaction(m ChatMessage, reaction string) error {
+	if m.ID == "" {
+		return nil
+	}
+	return d.session.MessageReactionAdd(m.ChannelID, m.ID, reaction)
+}
+
+func (d discordChat) RemoveReaction(m ChatMessage, reaction string) error {
+	if m.ID == "" {
+		return nil
+	}
+	return d.session.MessageReactionRemove(m.ChannelID, m.ID, reaction, "@me")
+}
+
+func (d discordChat) DirectMessage(m ChatMessage, text string) error {
+	directMessageChannel, err := d.session.UserChannelCreate(m.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = d.session.ChannelMessageSend(directMessageChannel.ID, text)
+	return err
+}
+
+func (d discordChat) Mention(m ChatMessage) string {
+	return fmt.Sprintf("<@%s>", m.UserID)
+}
+
+// discordInteraction is the ChatClient for slash commands, answered by editing their deferred response
+type discordInteraction struct {
+	session     *discordgo.Session
+	interaction *discordgo.InteractionCreate
+}
+
+func (d discordInteraction) Reply(m ChatMessage, text string) error {
+	_, err := d.session.InteractionResponseEdit(d.interaction.Interaction, &discordgo.WebhookEdit{
+		Content:    text,
+		Components: []discordgo.MessageComponent{},
+	})
+	return err
+}
+
+// AddReaction does nothing, slash commands have no message to react to
+func (d discordInteraction) AddReaction(m ChatMessage, reaction string) error {
+	return nil
+}
+
+func (d discordInteraction) RemoveReaction(m ChatMessage, reaction string) error {
+	return nil
+}
+
+// DirectMessage sends an ephemeral follow-up only the author sees
+func (d discordInteraction) DirectMessage(m ChatMessage, text string) error {
+	_, err := d.session.FollowupMessageCreate(d.interaction.Interaction, false, &discordgo.WebhookParams{
+		Content: text,
+		Flags:   uint64(discordgo.MessageFlagsEphemeral),
+	})
+	return err
+}
+
+func (d discordInteraction) Mention(m ChatMessage) string {
+	return fmt.Sprintf("<@%s>", m.UserID)
+}
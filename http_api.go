@@ -0,0 +1,385 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// how long POST /v1/request waits for the faucet worker to broadcast the transaction
+const httpResultTimeout = time.Minute
+
+type (
+	httpFundingRequest struct {
+		Address string `json:"address"`
+		// Chain is optional, the address prefix decides the chain when empty
+		Chain string `json:"chain"`
+		// Captcha is the token solved on the web page, API key holders don't need one
+		Captcha string `json:"captcha"`
+	}
+	httpFundingResponse struct {
+		TxHash string            `json:"tx_hash"`
+		Coins  cosmostypes.Coins `json:"coins"`
+		// Confirmed is false when the tx was broadcast but not seen in a block in time
+		Confirmed bool  `json:"confirmed"`
+		Height    int64 `json:"height,omitempty"`
+		// MergedInto is the recipient paid instead, when the request was merged with another one of its batch
+		MergedInto string `json:"merged_into,omitempty"`
+	}
+	httpChain struct {
+		Prefix        string `json:"prefix"`
+		Coins         string `json:"coins"`
+		Fees          string `json:"fees"`
+		Interval      string `json:"interval"`
+		RolesRequired bool   `json:"roles_required"`
+	}
+	// httpStatus keeps the first account as address and the total as balances
+	httpStatus struct {
+		Prefix   string            `json:"prefix"`
+		Address  string            `json:"address"`
+		Balances cosmostypes.Coins `json:"balances"`
+		Accounts []httpAccount     `json:"accounts"`
+	}
+	httpAccount struct {
+		Address  string            `json:"address"`
+		Balances cosmostypes.Coins `json:"balances"`
+	}
+	httpError struct {
+		Error string `json:"error"`
+	}
+)
+
+//go:embed web/index.html
+var indexPage string
+
+var indexTmpl = template.Must(template.New("index").Parse(indexPage))
+
+// HTTPHandler serves the faucet REST API and web page, sharing the faucet workers and receipts with
+// Discord. Requesters are identified by API key when they send one, by IP otherwise.
+type HTTPHandler struct {
+	fh FaucetHandler
+	// apiKeys maps each accepted key to the name used in receipts
+	apiKeys map[string]string
+	proxies trustedProxies
+	captcha CaptchaVerifier
+	// anonymous tells if requests without an API key are served, which needs a CAPTCHA
+	anonymous bool
+}
+
+// trustedProxies are the proxies whose X-Forwarded-For entries are believed
+type trustedProxies struct {
+	// peer trusts whichever address connects, for a single proxy in front of the faucet
+	peer bool
+	nets []*net.IPNet
+}
+
+// parseTrustedProxies parses TRUST_PROXY, either `true` for the connecting proxy only, or a
+// comma separated list of proxy IPs and CIDRs
+func parseTrustedProxies(raw string) (trustedProxies, error) {
+	var proxies trustedProxies
+	switch strings.TrimSpace(raw) {
+	case "", "false":
+		return proxies, nil
+	case "true":
+		proxies.peer = true
+		return proxies, nil
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return proxies, fmt.Errorf("TRUST_PROXY entries must be IPs or CIDRs: %w", err)
+		}
+		proxies.nets = append(proxies.nets, ipNet)
+	}
+	return proxies, nil
+}
+
+// trusts tells if ip is a proxy, peer is set for the address the request came from
+func (p trustedProxies) trusts(ip string, peer bool) bool {
+	if peer && p.peer {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewHTTPHandler parses API_KEYS, a comma separated list of `name:key` pairs
+func NewHTTPHandler(fh FaucetHandler, rawAPIKeys string, rawTrustProxy string, captcha CaptchaVerifier) (*HTTPHandler, error) {
+	apiKeys := make(map[string]string)
+	for _, pair := range strings.Split(rawAPIKeys, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("API_KEYS entries must look like name:key")
+		}
+		apiKeys[key] = name
+	}
+	// without a CAPTCHA, anonymous requests would only be limited by IP
+	_, noCaptcha := captcha.(NoopCaptcha)
+	if noCaptcha && len(apiKeys) == 0 {
+		return nil, fmt.Errorf("the HTTP API needs API_KEYS or a CAPTCHA_PROVIDER")
+	}
+	proxies, err := parseTrustedProxies(rawTrustProxy)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPHandler{fh: fh, apiKeys: apiKeys, proxies: proxies, captcha: captcha, anonymous: !noCaptcha}, nil
+}
+
+func (h *HTTPHandler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.handleIndex)
+	mux.HandleFunc("/v1/request", h.handleRequest)
+	mux.HandleFunc("/v1/chains", h.handleChains)
+	mux.HandleFunc("/v1/status/", h.handleStatus)
+	return mux
+}
+
+// ListenAndServe serves the API on addr in the background
+func (h *HTTPHandler) ListenAndServe(addr string) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h.Routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Infof("HTTP API listening on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	return srv
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, httpError{Error: err.Error()})
+}
+
+// clientIP returns the caller's address, taken from X-Forwarded-For behind a trusted proxy.
+// The client can write any left-most entries, so the header is read from the right and the
+// first address which isn't a trusted proxy wins.
+func (h *HTTPHandler) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !h.proxies.trusts(ip, true) {
+		return ip
+	}
+	var hops []string
+	for _, fwd := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(fwd, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// not written by a proxy, the last trusted hop is the best we know
+			break
+		}
+		ip = hop
+		if !h.proxies.trusts(ip, false) {
+			break
+		}
+	}
+	return ip
+}
+
+// requester identifies the caller, with ok false when an unknown API key was sent
+func (h *HTTPHandler) requester(r *http.Request) (requester Requester, trusted bool, ok bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		name, ok := h.apiKeys[key]
+		if !ok {
+			return Requester{}, false, false
+		}
+		return Requester{ID: "api:" + name, Name: name}, true, true
+	}
+
+	ip := h.clientIP(r)
+	return Requester{ID: "ip:" + ip, Name: ip}, false, true
+}
+
+func (h *HTTPHandler) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use POST"))
+		return
+	}
+	requester, trusted, ok := h.requester(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid API key"))
+		return
+	}
+	if !trusted && !h.anonymous {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("an API key is required"))
+		return
+	}
+
+	var body httpFundingRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body, err: %w", err))
+		return
+	}
+
+	// anonymous requests must solve the captcha before anything is reserved or queued
+	if !trusted {
+		if err := h.captcha.Verify(r.Context(), body.Captcha, h.clientIP(r)); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	// roles can't be checked over HTTP, only API key holders may use role gated chains
+	faucet, req, err := h.fh.prepareDefault(requester, strings.TrimSpace(body.Address), body.Chain, trusted)
+	var (
+		paused   pausedErr
+		role     roleRequiredErr
+		cooldown cooldownErr
+	)
+	switch {
+	case errors.As(err, &paused):
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	case errors.As(err, &role):
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s funding requires an API key", role.prefix))
+		return
+	case errors.As(err, &cooldown):
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	coins := req.Coins
+	result := make(FaucetResultChan, 1)
+	req.Responder = result
+	if err := h.fh.enqueue(faucet, req); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			writeError(w, http.StatusBadGateway, res.Err)
+			return
+		}
+		if res.Merged != "" {
+			writeJSON(w, http.StatusOK, httpFundingResponse{Coins: coins, MergedInto: res.Merged})
+			return
+		}
+		if res.Height == 0 {
+			writeJSON(w, http.StatusAccepted, httpFundingResponse{TxHash: res.TxHash, Coins: coins})
+			return
+		}
+		writeJSON(w, http.StatusOK, httpFundingResponse{TxHash: res.TxHash, Coins: coins, Confirmed: true, Height: res.Height})
+	case <-time.After(httpResultTimeout):
+		// the worker still owns the request and records its receipt
+		writeError(w, http.StatusAccepted, fmt.Errorf("request queued, the transaction was not broadcast yet"))
+	}
+}
+
+func (h *HTTPHandler) chains() []httpChain {
+	chains := make([]httpChain, 0, len(h.fh.chains))
+	for _, c := range h.fh.chains {
+		info := funding[c.Prefix]
+		chains = append(chains, httpChain{
+			Prefix:        c.Prefix,
+			Coins:         info.Coins,
+			Fees:          info.Fees,
+			Interval:      fundingInterval.String(),
+			RolesRequired: len(requiredRoles(c.Prefix)) > 0,
+		})
+	}
+	return chains
+}
+
+func (h *HTTPHandler) handleChains(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use GET"))
+		return
+	}
+	writeJSON(w, http.StatusOK, h.chains())
+}
+
+func (h *HTTPHandler) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var chains []httpChain
+	for _, c := range h.chains() {
+		// role gated chains can't be used from the page
+		if !c.RolesRequired {
+			chains = append(chains, c)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := indexTmpl.Execute(w, struct {
+		Chains  []httpChain
+		Captcha *CaptchaWidget
+	}{chains, h.captcha.Widget()})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (h *HTTPHandler) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use GET"))
+		return
+	}
+	prefix := strings.TrimPrefix(r.URL.Path, "/v1/status/")
+	c := h.fh.chains.FindByPrefix(prefix)
+	if c == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s chain prefix is not supported", prefix))
+		return
+	}
+	accounts, err := c.AccountBalances(r.Context())
+	if err != nil {
+		log.Error(err)
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to query balances"))
+		return
+	}
+	status := httpStatus{Prefix: prefix, Address: accounts[0].Address, Balances: cosmostypes.NewCoins()}
+	for _, account := range accounts {
+		status.Balances = status.Balances.Add(account.Coins...)
+		status.Accounts = append(status.Accounts, httpAccount{Address: account.Address, Balances: account.Coins})
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tokenCaptcha accepts its own value as the solved token
+type tokenCaptcha string
+
+func (c tokenCaptcha) Verify(ctx context.Context, token string, remoteIP string) error {
+	if token != string(c) {
+		return errors.New("captcha verification failed")
+	}
+	return nil
+}
+
+func (tokenCaptcha) Widget() *CaptchaWidget {
+	return nil
+}
+
+// postRequest posts body to /v1/request with apiKey, if set, and returns the status code
+func postRequest(t *testing.T, h *HTTPHandler, apiKey string, body string) int {
+	r := httptest.NewRequest(http.MethodPost, "/v1/request", strings.NewReader(body))
+	if apiKey != "" {
+		r.Header.Set("X-API-Key", apiKey)
+	}
+	w := httptest.NewRecorder()
+	h.Routes().ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestNewHTTPHandlerNeedsKeysOrCaptcha(t *testing.T) {
+	if _, err := NewHTTPHandler(FaucetHandler{}, "", "", NoopCaptcha{}); err == nil {
+		t.Error("served anonymous requests without a CAPTCHA")
+	}
+	if _, err := NewHTTPHandler(FaucetHandler{}, "ci", "", tokenCaptcha("solved")); err == nil {
+		t.Error("accepted an API key without a name")
+	}
+}
+
+func TestHTTPRequestAuth(t *testing.T) {
+	// an address no chain serves gets past the checks and fails with 400
+	const body = `{"address": "cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5lzv7xu", "captcha": "solved"}`
+	cases := []struct {
+		name    string
+		captcha CaptchaVerifier
+		apiKey  string
+		body    string
+		want    int
+	}{
+		{"anonymous without captcha", NoopCaptcha{}, "", body, http.StatusUnauthorized},
+		{"unknown key", NoopCaptcha{}, "wrong", body, http.StatusUnauthorized},
+		{"api key", NoopCaptcha{}, "secret", body, http.StatusBadRequest},
+		{"solved captcha", tokenCaptcha("solved"), "", body, http.StatusBadRequest},
+		{"unsolved captcha", tokenCaptcha("solved"), "", `{"address": "cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5lzv7xu"}`, http.StatusForbidden},
+		{"api key skips the captcha", tokenCaptcha("solved"), "secret", `{"address": "cosmos1qypqxpq9qcrsszg2pvxq6rs0zqg3yyc5lzv7xu"}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		h, err := NewHTTPHandler(FaucetHandler{}, "ci:secret", "", c.captcha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := postRequest(t, h, c.apiKey, c.body); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name       string
+		trustProxy string
+		remote     string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxy", "", "1.2.3.4:5678", []string{"9.9.9.9"}, "1.2.3.4"},
+		{"proxy disabled", "false", "1.2.3.4:5678", []string{"9.9.9.9"}, "1.2.3.4"},
+		{"peer proxy", "true", "10.0.0.1:80", []string{"9.9.9.9"}, "9.9.9.9"},
+		{"peer proxy without header", "true", "10.0.0.1:80", nil, "10.0.0.1"},
+		{"spoofed left-most entry", "true", "10.0.0.1:80", []string{"6.6.6.6, 9.9.9.9"}, "9.9.9.9"},
+		{"only the peer is trusted", "true", "10.0.0.1:80", []string{"9.9.9.9, 10.0.0.2"}, "10.0.0.2"},
+		{"malformed header", "true", "10.0.0.1:80", []string{"garbage"}, "10.0.0.1"},
+		{"malformed right-most entry", "true", "10.0.0.1:80", []string{"9.9.9.9, garbage"}, "10.0.0.1"},
+		{"malformed spoofed entry", "true", "10.0.0.1:80", []string{"garbage, 9.9.9.9"}, "9.9.9.9"},
+		{"proxy chain", "10.0.0.0/8,172.16.0.1", "10.0.0.1:80", []string{"6.6.6.6, 9.9.9.9, 172.16.0.1, 10.1.1.1"}, "9.9.9.9"},
+		{"untrusted peer", "10.0.0.0/8", "8.8.8.8:80", []string{"9.9.9.9"}, "8.8.8.8"},
+		{"split headers", "10.0.0.0/8", "10.0.0.1:80", []string{"6.6.6.6", "9.9.9.9, 10.2.2.2"}, "9.9.9.9"},
+		{"only proxies", "10.0.0.0/8", "10.0.0.1:80", []string{"10.1.1.1, 10.2.2.2"}, "10.1.1.1"},
+		{"ipv6", "::1", "[::1]:80", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, c := range cases {
+		h, err := NewHTTPHandler(FaucetHandler{}, "ci:secret", c.trustProxy, NoopCaptcha{})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/v1/request", nil)
+		r.RemoteAddr = c.remote
+		for _, fwd := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", fwd)
+		}
+		if got := h.clientIP(r); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, raw := range []string{"10.0.0.0/33", "proxy.local", "10.0.0.1,nope"} {
+		if _, err := parseTrustedProxies(raw); err == nil {
+			t.Errorf("parsed %q", raw)
+		}
+	}
+	proxies, err := parseTrustedProxies(" 10.0.0.1 , ,2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{"10.0.0.1": true, "10.0.0.2": false, "2001:db8::5": true, "garbage": false} {
+		if got := proxies.trusts(ip, true); got != want {
+			t.Errorf("trusts(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
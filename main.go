@@ -4,7 +4,10 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"regexp"
 	"strings"
 	"time"
@@ -13,6 +16,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/Entrio/subenv"
 	"github.com/bwmarrin/discordgo"
 	"github.com/cosmos/btcutil/bech32"
 	cosmostypes "github.com/cosmos/cosmos-sdk/types"
@@ -33,19 +37,42 @@ type FeesStr = string
 type ChainFundingInfo struct {
 	Coins CoinsStr `json:"coins"`
 	Fees  FeesStr  `json:"fees"`
+	// Roles lists role names or IDs, one of which is required to request funding. Overrides ROLE_REQUIRED.
+	Roles []string `json:"roles"`
+	// Tiers give members with certain roles a different amount or interval, highest tier first
+	Tiers []FundingTier `json:"tiers"`
+}
+type FundingTier struct {
+	// Roles lists role names or IDs granting this tier
+	Roles []string `json:"roles"`
+	Coins CoinsStr `json:"coins"`
+	// Interval is optional, e.g. `6h`, and defaults to FUNDING_INTERVAL
+	Interval string `json:"interval"`
 }
 type ChainFunding = map[db.ChainPrefix]ChainFundingInfo
 
 var (
 	mnemonic           = os.Getenv("MNEMONIC")
+	treasuryMnemonic   = os.Getenv("TREASURY_MNEMONIC")
 	botToken           = os.Getenv("BOT_TOKEN")
 	rawChains          = os.Getenv("CHAINS")
 	rawFunding         = os.Getenv("FUNDING")
 	rawFundingInterval = os.Getenv("FUNDING_INTERVAL")
+	rawShutdownTimeout = os.Getenv("SHUTDOWN_TIMEOUT")
 	isSilent           = os.Getenv("SILENT") != ""
+	prefixCommands     = subenv.EnvB("PREFIX_COMMANDS", true)
+	commandsGuildID    = os.Getenv("COMMANDS_GUILD_ID")
+	dbBackend          = os.Getenv("DB_BACKEND")
+	dbPath             = os.Getenv("DB_PATH")
+	firestoreColl      = os.Getenv("FIRESTORE_COLLECTION")
+	rawRoleRequired    = os.Getenv("ROLE_REQUIRED")
 	funding            ChainFunding
 	fundingInterval    time.Duration
+	shutdownTimeout    = 30 * time.Second
 	pruneMode          = false
+
+	// defaultRequiredRoles applies to chains that don't list their own roles
+	defaultRequiredRoles []string
 )
 
 func init() {
@@ -69,7 +96,10 @@ func init() {
 	} else {
 		log.SetFormatter(&log.TextFormatter{})
 	}
+}
 
+// loadConfig validates the environment and fills in the defaults
+func loadConfig() {
 	if mnemonic == "" {
 		log.Fatal("MNEMONIC is invalid")
 	}
@@ -92,6 +122,24 @@ func init() {
 			log.Fatal(err)
 		}
 	}
+	if rawShutdownTimeout != "" {
+		var err error
+		shutdownTimeout, err = time.ParseDuration(rawShutdownTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if dbPath == "" {
+		dbPath = "fonzie.db"
+	}
+	if firestoreColl == "" {
+		firestoreColl = "receipts"
+	}
+	for _, role := range strings.Split(rawRoleRequired, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			defaultRequiredRoles = append(defaultRequiredRoles, role)
+		}
+	}
 }
 
 func initChains() chain.Chains {
@@ -104,46 +152,101 @@ func initChains() chain.Chains {
 
 	for _, c := range chains {
 		fmt.Println(c)
+		if _, _, err := c.Batching(); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := c.Confirmation(); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := c.TopUpInterval(); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := c.AlertInterval(); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	fmt.Println(chains)
-	// parse chains config
-	err = json.Unmarshal([]byte(rawFunding), &funding)
+	return chains
+}
+
+// initFunding parses the funding config and returns how long receipts must be kept, the
+// longest interval of FUNDING_INTERVAL and the tiers
+func initFunding() time.Duration {
+	err := json.Unmarshal([]byte(rawFunding), &funding)
 	if err != nil {
 		log.Fatal(err)
 	}
+	retention := fundingInterval
+	for prefix, info := range funding {
+		for _, tier := range info.Tiers {
+			_, interval, err := tier.parse()
+			if err != nil {
+				log.Fatalf("invalid funding tier for %s: %v", prefix, err)
+			}
+			if interval > retention {
+				retention = interval
+			}
+		}
+	}
 	log.Printf("CHAIN_FUNDING: %#v", funding)
-	return chains
+	return retention
 }
 
 func main() {
-	ctx := context.Background()
-	db := db.NewDb(ctx)
+	loadConfig()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// a receipt has to outlive the longest cooldown it can be checked against
+	retention := initFunding()
+	store, err := db.NewReceiptStore(ctx, db.StoreConfig{
+		Backend:    dbBackend,
+		Path:       dbPath,
+		Collection: firestoreColl,
+		TTL:        retention,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	db := db.NewDb(ctx, store)
+	defer db.Close()
 
 	if pruneMode {
-		numPruned, err := db.PruneExpiredReceipts(ctx, time.Now().Add(-fundingInterval))
+		numPruned, err := db.PruneExpiredReceipts(ctx, time.Now().Add(-retention))
 		if err != nil {
 			log.Fatal(err)
 		}
 		log.Infof("pruned %d receipts", numPruned)
+		db.Close()
 		os.Exit(0)
 	}
 
 	go func() {
 		for {
 			log.Info("Pruning thread started...")
-			numPruned, err := db.PruneExpiredReceipts(ctx, time.Now().Add(-fundingInterval))
+			numPruned, err := db.PruneExpiredReceipts(ctx, time.Now().Add(-retention))
+			if ctx.Err() != nil {
+				return
+			}
 			if err != nil {
 				log.Fatal(err)
 			}
 			log.Infof("pruned %d receipts", numPruned)
-			time.Sleep(time.Second * 30)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second * 30):
+			}
 		}
 	}()
 
 	chains := initChains()
 
-	err := chains.ImportMnemonic(ctx, mnemonic)
+	err = chains.ImportMnemonic(ctx, mnemonic)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = chains.ImportTreasury(ctx, treasuryMnemonic)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -156,10 +259,53 @@ func main() {
 	defer dg.Close()
 
 	fh := NewFaucetHandler(chains, db)
-	dg.AddHandler(fh.handleDispense)
 
-	// we only care about receiving message events.
-	dg.Identify.Intents = discordgo.IntentsGuildMessages
+	var adapters []ChatAdapter
+	if telegramToken := os.Getenv("TELEGRAM_TOKEN"); telegramToken != "" {
+		adapters = append(adapters, NewTelegramAdapter(telegramToken, subenv.Env("TELEGRAM_API_URL", "https://api.telegram.org")))
+	}
+	if matrixToken := os.Getenv("MATRIX_TOKEN"); matrixToken != "" {
+		adapters = append(adapters, NewMatrixAdapter(subenv.Env("MATRIX_HOMESERVER", "https://matrix.org"), matrixToken))
+	}
+	if slackAppToken := os.Getenv("SLACK_APP_TOKEN"); slackAppToken != "" {
+		adapters = append(adapters, NewSlackAdapter(subenv.Env("SLACK_API_URL", "https://slack.com/api"), slackAppToken, os.Getenv("SLACK_BOT_TOKEN")))
+	}
+	for _, adapter := range adapters {
+		fh.RegisterResponder(adapter.Name(), chatResponder(adapter))
+		go runChatAdapter(ctx, fh, adapter)
+	}
+	fh.RegisterResponder("discord", discordResponder(dg))
+
+	// The REST API and web page run next to the Discord bot when enabled. BIND_PORT alone doesn't
+	// enable it since the Docker image sets a port.
+	var srv *http.Server
+	if bindPort := os.Getenv("BIND_PORT"); subenv.EnvB("HTTP_API", false) && bindPort != "" {
+		captcha, err := NewCaptchaVerifier(os.Getenv("CAPTCHA_PROVIDER"), os.Getenv("CAPTCHA_SITE_KEY"), os.Getenv("CAPTCHA_SECRET"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		api, err := NewHTTPHandler(fh, os.Getenv("API_KEYS"), os.Getenv("TRUST_PROXY"), captcha)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv = api.ListenAndServe(net.JoinHostPort(subenv.Env("BIND_IP", "0.0.0.0"), bindPort))
+	}
+
+	dg.AddHandler(fh.handleInteraction)
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
+		err := registerCommands(s, chains)
+		if err != nil {
+			log.Error(err)
+		}
+	})
+
+	// guild events keep the role cache used by ROLE_REQUIRED up to date
+	dg.Identify.Intents = discordgo.IntentsGuilds
+	if prefixCommands {
+		// `!` commands need to read message content
+		dg.AddHandler(fh.handleDispense)
+		dg.Identify.Intents |= discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
+	}
 	// dg.Identify.Intents = discordgo.IntentDirectMessages
 
 	// Open a websocket connection to Discord and begin listening.
@@ -168,6 +314,24 @@ func main() {
 		log.Fatal(err)
 	}
 
+	admin := newAdminChannel(dg, os.Getenv("ADMIN_CHANNEL_ID"), os.Getenv("ADMIN_WEBHOOK_URL"))
+	for _, c := range chains {
+		if c.TopUp != nil {
+			go watchTreasury(ctx, c, admin)
+		}
+		if c.Alerts != nil {
+			go fh.watchBalance(ctx, c, admin)
+		}
+	}
+
+	// requests accepted before a crash or restart, or by a replica which stopped, are sent once their
+	// lease expired, now that every frontend can be answered
+	go fh.ReplayAbandoned(ctx)
+	// the leases of this process outlive ctx, the workers still send queued requests while draining
+	leaseCtx, stopLeases := context.WithCancel(context.Background())
+	defer stopLeases()
+	go fh.RenewLeases(leaseCtx)
+
 	// Wait here until CTRL-C or other term signal is received.
 	if isSilent {
 		log.Info("SILENT MODE:  The Fonz is still running, only reporting errors.  Press CTRL-C to exit.")
@@ -177,16 +341,39 @@ func main() {
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
+
+	log.Info("Shutting down, sending the pending requests")
+	// stop polling the chat frontends; replies to queued requests still go out
+	cancel()
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelDrain()
+	if err := fh.Shutdown(drainCtx, 10*time.Second); err != nil {
+		log.Error(err)
+	}
+	if srv != nil {
+		// lets API requests waiting on the workers write their response
+		httpCtx, cancelHTTP := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelHTTP()
+		if err := srv.Shutdown(httpCtx); err != nil {
+			log.Error(err)
+		}
+	}
+	// the Discord session and the db are closed by the deferred calls
+	log.Info("The Fonz is gone")
 }
 
 type FaucetHandler struct {
 	faucets map[string]ChainFaucet
-	quit    chan bool
-	chains  chain.Chains
-	db      *db.Db
-	ctx     context.Context
+	life    *lifecycle
+	// responders rebuild the Responder of replayed requests, by frontend
+	responders map[string]ResponderFactory
+	chains     chain.Chains
+	db         *db.Db
+	ctx        context.Context
 
 	cmd *regexp.Regexp
+	// balances pauses the chains whose faucet balance is critical
+	balances *balanceMonitor
 }
 
 func NewFaucetHandler(chains chain.Chains, db *db.Db) FaucetHandler {
@@ -195,19 +382,22 @@ func NewFaucetHandler(chains chain.Chains, db *db.Db) FaucetHandler {
 		log.Fatal(err)
 	}
 	var faucets = make(map[string]ChainFaucet)
-	var quit = make(chan bool)
+	var life = newLifecycle()
 	for _, c := range chains {
-		f := ChainFaucet{make(chan FaucetReq), make(chan StatusReq), c}
+		f := ChainFaucet{channel: make(chan FaucetReq), status: make(chan StatusReq), chain: c, db: db, abort: life.abort}
 		faucets[c.Prefix] = f
-		go f.Consume(quit)
+		life.workers.Add(1)
+		go f.Consume(life.quit, &life.workers)
 	}
 	return FaucetHandler{
-		faucets: faucets,
-		quit:    quit,
-		chains:  chains,
-		cmd:     re,
-		ctx:     context.Background(),
-		db:      db,
+		faucets:    faucets,
+		life:       life,
+		responders: map[string]ResponderFactory{},
+		balances:   newBalanceMonitor(),
+		chains:     chains,
+		cmd:        re,
+		ctx:        context.Background(),
+		db:         db,
 	}
 }
 
@@ -219,9 +409,14 @@ func (fh FaucetHandler) handleDispense(s *discordgo.Session, m *discordgo.Messag
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
+	c := discordCmd{session: s, msg: m}
 
 	// Do we support this bech32 prefix?
 	matches := fh.cmd.FindAllStringSubmatch(m.Content, -1)
+	if len(matches) > maxCommandsPerMessage {
+		c.reportError(errTooManyCommands)
+		return
+	}
 	if len(matches) > 0 {
 		// for each matched request, do--
 		for _, match := range matches {
@@ -229,147 +424,225 @@ func (fh FaucetHandler) handleDispense(s *discordgo.Session, m *discordgo.Messag
 			args := strings.TrimSpace(match[2])
 			switch cmd {
 			case "request":
-				// TODO if role doesn't exist, reply with help and return
-				// - "umeemaniac"
-				// - ROLE_REQUIRED="role string/id", optional from env
-				dstAddr := args
-				prefix, _, err := bech32.Decode(dstAddr, 1023)
-				if err != nil {
-					reportError(s, m, err)
+				if !fh.request(c, args, "") {
 					return
 				}
-
-				faucet, ok := fh.faucets[prefix]
-				if !ok {
-					reportError(s, m, fmt.Errorf("%s chain prefix is not supported", prefix))
-					return
-				}
-				coins, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Coins)
-				if err != nil {
-					reportError(s, m, err)
-					return
-				}
-				fees, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Fees)
-				if err != nil {
-					reportError(s, m, err)
-					return
-				}
-
-				receipt, err := fh.db.GetFundingReceiptByUsernameAndChainPrefix(fh.ctx, m.Author.ID, prefix)
-				if err != nil {
-					log.Error(err)
-					return
-				}
-
-				if receipt != nil {
-					log.Infof("FETCHED RECEIPT RESULT: %#v", receipt.FundedAt.Add(fundingInterval).After(time.Now()))
-				}
-
-				if receipt != nil && receipt.FundedAt.Add(fundingInterval).After(time.Now()) {
-					reportError(s, m, fmt.Errorf("you must wait %v until you can get %s funding again", time.Until(receipt.FundedAt.Add(fundingInterval)).Round(2*time.Second), prefix))
-					return
-				}
-
-				recipient, err := faucet.chain.DecodeAddr(dstAddr)
-				if err != nil {
-					reportError(s, m, fmt.Errorf("malformed destination address, err: %w", err))
-					return
-				}
-
-				// Immediately respond to Discord
-				sendReaction(s, m, "👍")
-				sendReaction(s, m, "⚙️")
-				faucet.channel <- FaucetReq{recipient, coins, fees, s, m}
-
-				err = fh.db.SaveFundingReceipt(fh.ctx, db.FundingReceipt{
-					ChainPrefix: prefix,
-					Username:    m.Author.ID,
-					FundedAt:    time.Now(),
-					Amount:      coins,
-				})
-				if err != nil {
-					log.Error(err)
-				}
-
 			case "status":
-				// Display faucet status
-				faucet, ok := fh.faucets["andr"]
-				if !ok {
-					reportError(s, m, fmt.Errorf("%s chain prefix is not supported", "andr"))
-					return
-				}
-				sendReaction(s, m, "⚙️")
-				faucet.status <- StatusReq{s, m}
+				fh.status(c, args)
 			default:
-				help(s, m, fh.chains)
+				help(c, fh.chains)
 			}
 		}
 	} else if m.GuildID == "" {
 		// If message is DM, respond with help
-		help(s, m, fh.chains)
+		help(c, fh.chains)
 	}
 }
 
-func reportError(s *discordgo.Session, m *discordgo.MessageCreate, errToReport error) {
-	if m.Author.Bot {
-		// guard against known bots
-		return
+// maxCommandsPerMessage caps the `!` commands answered in one message
+const maxCommandsPerMessage = 3
+
+var errTooManyCommands = fmt.Errorf("send at most %d commands per message", maxCommandsPerMessage)
+
+// cooldownErr tells the requester their request was refused because of a cooldown
+type cooldownErr struct {
+	msg string
+}
+
+func (e cooldownErr) Error() string {
+	return e.msg
+}
+
+// faucetFor returns the faucet serving the chain of a bech32 address. When chainPrefix
+// is not empty, the address must belong to that chain.
+func (fh FaucetHandler) faucetFor(dstAddr string, chainPrefix string) (ChainFaucet, error) {
+	prefix, _, err := bech32.Decode(dstAddr, 1023)
+	if err != nil {
+		return ChainFaucet{}, err
+	}
+	if chainPrefix != "" && prefix != chainPrefix {
+		return ChainFaucet{}, fmt.Errorf("%s is not a %s address", dstAddr, chainPrefix)
+	}
+
+	faucet, ok := fh.faucets[prefix]
+	if !ok {
+		return ChainFaucet{}, fmt.Errorf("%s chain prefix is not supported", prefix)
+	}
+	if fh.balances.paused(prefix) {
+		return ChainFaucet{}, pausedErr{prefix}
 	}
-	err := sendReaction(s, m, "❌")
+	return faucet, nil
+}
+
+// prepare validates a funding request of requester and reserves its funding slot. The returned
+// request is ready to be sent to the faucet's channel once its Responder is set.
+func (fh FaucetHandler) prepare(faucet ChainFaucet, requester Requester, dstAddr string, coins cosmostypes.Coins, interval time.Duration) (FaucetReq, error) {
+	prefix := faucet.chain.Prefix
+	fees, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Fees)
 	if err != nil {
-		log.Error(err)
+		return FaucetReq{}, err
+	}
+
+	recipient, err := faucet.chain.DecodeAddr(dstAddr)
+	if err != nil {
+		return FaucetReq{}, fmt.Errorf("malformed destination address, err: %w", err)
+	}
+
+	// Check the cooldown and reserve the funding slot in one step, so concurrent
+	// requests from the same user cannot both get through
+	receipt, err := fh.db.ReserveFundingSlot(fh.ctx, requester.ID, prefix, strings.ToLower(dstAddr), coins, interval)
+	var cooldown *db.CooldownError
+	if errors.As(err, &cooldown) {
+		wait := time.Until(cooldown.Until).Round(2 * time.Second)
+		if cooldown.Receipt.Username != requester.ID {
+			return FaucetReq{}, cooldownErr{fmt.Sprintf("%s was already funded recently, it can receive %s funding again in %v", dstAddr, prefix, wait)}
+		}
+		return FaucetReq{}, cooldownErr{fmt.Sprintf("you must wait %v until you can get %s funding again", wait, prefix)}
 	}
-	// Send errors to channel, even when isSilent
-	_, err = s.ChannelMessageSendReply(m.ChannelID, fmt.Sprintf("<@%s>, there is an error in your request:\n `%s`", m.Author.ID, errToReport), m.Reference())
 	if err != nil {
 		log.Error(err)
+		return FaucetReq{}, fmt.Errorf("could not reserve %s funding, please try again later", prefix)
 	}
+
+	return FaucetReq{
+		Recipient: recipient,
+		Coins:     coins,
+		Fees:      fees,
+		Requester: requester,
+		receipt:   *receipt,
+	}, nil
 }
 
-//go:embed help.md
-var helpMsg string
+// roleRequiredErr refuses a role gated chain to a frontend which can't check roles
+type roleRequiredErr struct {
+	prefix string
+}
 
-func help(s *discordgo.Session, m *discordgo.MessageCreate, chains chain.Chains) {
-	acc := []string{}
-	for _, chain := range chains {
-		acc = append(acc, chain.Prefix)
+func (e roleRequiredErr) Error() string {
+	return fmt.Sprintf("%s funding requires a Discord role, please request it on Discord", e.prefix)
+}
+
+// prepareDefault validates a funding request from a frontend without roles and reserves its
+// funding slot for the chain's default funding. Role gated chains are refused unless the
+// requester is trusted, like API key holders.
+func (fh FaucetHandler) prepareDefault(requester Requester, dstAddr string, chainPrefix string, trusted bool) (ChainFaucet, FaucetReq, error) {
+	faucet, err := fh.faucetFor(dstAddr, chainPrefix)
+	if err != nil {
+		return ChainFaucet{}, FaucetReq{}, err
+	}
+	prefix := faucet.chain.Prefix
+	if len(requiredRoles(prefix)) > 0 && !trusted {
+		return ChainFaucet{}, FaucetReq{}, roleRequiredErr{prefix}
 	}
-	err := sendMessage(s, m, fmt.Sprintf("**Supported address prefixes**: %s.\n\n%s", strings.Join(acc, ", "), helpMsg))
+	coins, interval, err := defaultTier(prefix)
 	if err != nil {
-		log.Error(err)
+		return ChainFaucet{}, FaucetReq{}, err
 	}
+	req, err := fh.prepare(faucet, requester, dstAddr, coins, interval)
+	if err != nil {
+		return ChainFaucet{}, FaucetReq{}, err
+	}
+	return faucet, req, nil
 }
 
-func isDM(m *discordgo.MessageCreate) bool {
-	return m.GuildID == ""
+// request validates a funding request from Discord and queues it on the chain's faucet.
+// It returns false when the request was rejected.
+func (fh FaucetHandler) request(c discordCmd, dstAddr string, chainPrefix string) bool {
+	faucet, err := fh.faucetFor(dstAddr, chainPrefix)
+	if err != nil {
+		c.reportError(err)
+		return false
+	}
+	prefix := faucet.chain.Prefix
+	if err := checkRoles(c, prefix); err != nil {
+		c.reportError(err)
+		return false
+	}
+	coins, interval, err := resolveTier(c, prefix)
+	if err != nil {
+		c.reportError(err)
+		return false
+	}
+
+	req, err := fh.prepare(faucet, c.requester(), dstAddr, coins, interval)
+	if err != nil {
+		c.reportError(err)
+		return false
+	}
+	req.Responder = c
+
+	// Immediately respond to Discord
+	c.sendReaction("👍")
+	c.sendReaction("⚙️")
+	if err := fh.enqueue(faucet, req); err != nil {
+		c.removedReaction("⚙️")
+		c.reportError(err)
+		return false
+	}
+	return true
 }
 
-func sendMessage(s *discordgo.Session, m *discordgo.MessageCreate, msg string) error {
-	if m.Author.Bot || isSilent && !isDM(m) {
-		// Silent mode is enabled, so-- only reply to DMs
-		return nil
+// statusReport asks the faucet workers for the balances of one chain, or of every chain when prefix is empty
+func (fh FaucetHandler) statusReport(prefix string) (string, error) {
+	chains := fh.chains
+	if prefix != "" {
+		ch := fh.chains.FindByPrefix(prefix)
+		if ch == nil {
+			return "", fmt.Errorf("%s chain prefix is not supported", prefix)
+		}
+		chains = chain.Chains{ch}
+	}
+
+	// each worker answers on its own channel to keep the chains in order
+	reports := make([]chan string, 0, len(chains))
+	for _, ch := range chains {
+		report := make(chan string, 1)
+		select {
+		case fh.faucets[ch.Prefix].status <- StatusReq{report}:
+		case <-fh.life.quit:
+			return "", errShuttingDown
+		}
+		reports = append(reports, report)
 	}
-	directMessageChannel, err := s.UserChannelCreate(m.Author.ID)
+	var lines []string
+	for _, report := range reports {
+		lines = append(lines, <-report)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// status reports the faucet balances of one chain, or of every chain when prefix is empty
+func (fh FaucetHandler) status(c discordCmd, prefix string) {
+	c.sendReaction("⚙️")
+	report, err := fh.statusReport(prefix)
 	if err != nil {
-		return err
+		c.removedReaction("⚙️")
+		c.reportError(err)
+		return
 	}
-	_, err = s.ChannelMessageSend(directMessageChannel.ID, msg)
+
+	c.removedReaction("⚙️")
+	c.sendReaction("✅")
+	err = c.reply(fmt.Sprintf("Faucet status:\n%s\nSend DMs: `%v`", report, subenv.EnvB("SEND_DM", false)))
 	if err != nil {
-		return err
+		log.Error(err)
 	}
-	return nil
 }
 
-func sendReaction(s *discordgo.Session, m *discordgo.MessageCreate, reaction string) error {
-	if isSilent || m.Author.Bot {
-		return nil
+//go:embed help.md
+var helpMsg string
+
+func helpText(chains chain.Chains) string {
+	acc := []string{}
+	for _, chain := range chains {
+		acc = append(acc, chain.Prefix)
 	}
-	return s.MessageReactionAdd(m.ChannelID, m.ID, reaction)
+	return fmt.Sprintf("**Supported address prefixes**: %s.\n\n%s", strings.Join(acc, ", "), helpMsg)
 }
 
-func removedReaction(s *discordgo.Session, m *discordgo.MessageCreate, reaction string) error {
-	if isSilent || m.Author.Bot {
-		return nil
+func help(c discordCmd, chains chain.Chains) {
+	err := c.sendMessage(helpText(chains))
+	if err != nil {
+		log.Error(err)
 	}
-	return s.MessageReactionRemove(m.ChannelID, m.ID, reaction, "@me")
 }
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// how long a /sync call waits for new events
+const matrixSyncTimeout = 30 * time.Second
+
+type (
+	mxError struct {
+		ErrCode string `json:"errcode"`
+		Error   string `json:"error"`
+	}
+	mxSync struct {
+		NextBatch string `json:"next_batch"`
+		Rooms     struct {
+			Join   map[string]mxJoinedRoom `json:"join"`
+			Invite map[string]struct{}     `json:"invite"`
+		} `json:"rooms"`
+	}
+	mxJoinedRoom struct {
+		Summary struct {
+			JoinedMembers int `json:"m.joined_member_count"`
+		} `json:"summary"`
+		Timeline struct {
+			Events []mxEvent `json:"events"`
+		} `json:"timeline"`
+	}
+	mxEvent struct {
+		Type    string `json:"type"`
+		EventID string `json:"event_id"`
+		Sender  string `json:"sender"`
+		Content struct {
+			MsgType string `json:"msgtype"`
+			Body    string `json:"body"`
+		} `json:"content"`
+	}
+)
+
+// MatrixAdapter is a Matrix chat frontend using the client-server API with an access token
+type MatrixAdapter struct {
+	client *resty.Client
+	userID string
+	txn    int64
+
+	mu sync.Mutex
+	// joined member count per room, rooms with two members are DMs
+	members map[string]int
+	// event IDs of our reactions, to redact them on removal
+	reactions map[string]string
+	// direct room per user, loaded from the m.direct account data
+	direct map[string]string
+	// dmMu serializes opening direct rooms, so one is created per user
+	dmMu sync.Mutex
+}
+
+// NewMatrixAdapter talks to the homeserver at homeserverURL, e.g. https://matrix.org
+func NewMatrixAdapter(homeserverURL string, token string) *MatrixAdapter {
+	return &MatrixAdapter{
+		client: resty.New().
+			SetBaseURL(strings.TrimRight(homeserverURL, "/") + "/_matrix/client/v3").
+			SetAuthToken(token).
+			SetTimeout(matrixSyncTimeout + 10*time.Second),
+		members:   map[string]int{},
+		reactions: map[string]string{},
+	}
+}
+
+func (ma *MatrixAdapter) Name() string {
+	return "matrix"
+}
+
+func (ma *MatrixAdapter) IDPrefix() string {
+	return "matrix"
+}
+
+// do sends a request and decodes the response into result
+func (ma *MatrixAdapter) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var mxErr mxError
+	req := ma.client.R().
+		SetContext(ctx).
+		SetError(&mxErr).
+		ForceContentType("application/json")
+	if body != nil {
+		req.SetBody(body)
+	}
+	if result != nil {
+		req.SetResult(result)
+	}
+	resp, err := req.Execute(method, path)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("matrix %s failed; http code %d: %s %s", path, resp.StatusCode(), mxErr.ErrCode, mxErr.Error)
+	}
+	return nil
+}
+
+// send sends an event to a room and returns its ID
+func (ma *MatrixAdapter) send(roomID string, eventType string, content interface{}) (string, error) {
+	var result struct {
+		EventID string `json:"event_id"`
+	}
+	txnID := fmt.Sprintf("fonzie%d.%d", time.Now().UnixNano(), atomic.AddInt64(&ma.txn, 1))
+	path := fmt.Sprintf("/rooms/%s/send/%s/%s", url.PathEscape(roomID), eventType, txnID)
+	err := ma.do(context.Background(), resty.MethodPut, path, content, &result)
+	return result.EventID, err
+}
+
+// Listen syncs with the homeserver until ctx is done. Messages sent before the bot started are skipped.
+func (ma *MatrixAdapter) Listen(ctx context.Context, handle func(ChatMessage)) error {
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := ma.do(ctx, resty.MethodGet, "/account/whoami", nil, &whoami); err != nil {
+		return err
+	}
+	ma.userID = whoami.UserID
+
+	since := ""
+	for ctx.Err() == nil {
+		var sync mxSync
+		params := url.Values{"timeout": {strconv.Itoa(int(matrixSyncTimeout.Milliseconds()))}}
+		if since != "" {
+			params.Set("since", since)
+		}
+		err := ma.do(ctx, resty.MethodGet, "/sync?"+params.Encode(), nil, &sync)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Error(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for roomID := range sync.Rooms.Invite {
+			if err := ma.do(ctx, resty.MethodPost, "/join/"+url.PathEscape(roomID), map[string]string{}, nil); err != nil {
+				log.Error(err)
+			}
+		}
+		for roomID, room := range sync.Rooms.Join {
+			ma.mu.Lock()
+			if room.Summary.JoinedMembers > 0 {
+				ma.members[roomID] = room.Summary.JoinedMembers
+			}
+			isDM := ma.members[roomID] == 2
+			ma.mu.Unlock()
+			if since == "" {
+				// the initial sync holds history
+				continue
+			}
+			for _, ev := range room.Timeline.Events {
+				if ev.Type != "m.room.message" || ev.Content.MsgType != "m.text" || ev.Sender == ma.userID {
+					continue
+				}
+				if isDM {
+					ma.mu.Lock()
+					if ma.direct != nil && ma.direct[ev.Sender] == "" {
+						ma.direct[ev.Sender] = roomID
+					}
+					ma.mu.Unlock()
+				}
+				handle(ChatMessage{
+					ID:        ev.EventID,
+					ChannelID: roomID,
+					UserID:    ev.Sender,
+					Text:      stripMatrixReplyFallback(ev.Content.Body),
+					IsDM:      isDM,
+				})
+			}
+		}
+		since = sync.NextBatch
+	}
+	return nil
+}
+
+// stripMatrixReplyFallback removes the quoted message clients prepend to replies
+func stripMatrixReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	for len(lines) > 0 && strings.HasPrefix(lines[0], ">") {
+		lines = lines[1:]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Reply answers in the room of m. Matrix shows Discord's **bold** markers literally, so drop them.
+func (ma *MatrixAdapter) Reply(m ChatMessage, text string) error {
+	_, err := ma.send(m.ChannelID, "m.room.message", map[string]interface{}{
+		"msgtype": "m.notice",
+		"body":    strings.ReplaceAll(text, "**", ""),
+		"m.relates_to": map[string]interface{}{
+			"m.in_reply_to": map[string]string{"event_id": m.ID},
+		},
+	})
+	return err
+}
+
+func (ma *MatrixAdapter) AddReaction(m ChatMessage, reaction string) error {
+	eventID, err := ma.send(m.ChannelID, "m.reaction", map[string]interface{}{
+		"m.relates_to": map[string]string{
+			"rel_type": "m.annotation",
+			"event_id": m.ID,
+			"key":      reaction,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ma.mu.Lock()
+	ma.reactions[m.ID+reaction] = eventID
+	ma.mu.Unlock()
+	return nil
+}
+
+// RemoveReaction redacts a reaction added by AddReaction
+func (ma *MatrixAdapter) RemoveReaction(m ChatMessage, reaction string) error {
+	ma.mu.Lock()
+	eventID, ok := ma.reactions[m.ID+reaction]
+	delete(ma.reactions, m.ID+reaction)
+	ma.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	txnID := fmt.Sprintf("fonzie%d.%d", time.Now().UnixNano(), atomic.AddInt64(&ma.txn, 1))
+	path := fmt.Sprintf("/rooms/%s/redact/%s/%s", url.PathEscape(m.ChannelID), url.PathEscape(eventID), txnID)
+	return ma.do(context.Background(), resty.MethodPut, path, map[string]string{}, nil)
+}
+
+// DirectMessage answers in the room of m when it is a DM, otherwise in the direct room of the author
+func (ma *MatrixAdapter) DirectMessage(m ChatMessage, text string) error {
+	roomID := m.ChannelID
+	if !m.IsDM {
+		var err error
+		roomID, err = ma.directRoom(m.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := ma.send(roomID, "m.room.message", map[string]string{
+		"msgtype": "m.notice",
+		"body":    strings.ReplaceAll(text, "**", ""),
+	})
+	return err
+}
+
+// directRoom returns the direct room with userID, creating it and recording it in the m.direct
+// account data when there is none the bot is still in
+func (ma *MatrixAdapter) directRoom(userID string) (string, error) {
+	ma.dmMu.Lock()
+	defer ma.dmMu.Unlock()
+	ctx := context.Background()
+	path := fmt.Sprintf("/user/%s/account_data/m.direct", url.PathEscape(ma.userID))
+
+	// m.direct lists the rooms of each user, rooms we left may still be in there
+	direct := map[string][]string{}
+	ma.mu.Lock()
+	loaded := ma.direct != nil
+	ma.mu.Unlock()
+	if !loaded {
+		var mxErr mxError
+		resp, err := ma.client.R().SetContext(ctx).SetError(&mxErr).SetResult(&direct).ForceContentType("application/json").Get(path)
+		if err != nil {
+			return "", err
+		}
+		if resp.IsError() && mxErr.ErrCode != "M_NOT_FOUND" {
+			return "", fmt.Errorf("matrix %s failed; http code %d: %s %s", path, resp.StatusCode(), mxErr.ErrCode, mxErr.Error)
+		}
+		ma.mu.Lock()
+		ma.direct = map[string]string{}
+		for user, rooms := range direct {
+			for _, roomID := range rooms {
+				if ma.members[roomID] > 0 {
+					ma.direct[user] = roomID
+				}
+			}
+		}
+		ma.mu.Unlock()
+	}
+	ma.mu.Lock()
+	roomID := ma.direct[userID]
+	ma.mu.Unlock()
+	if roomID != "" {
+		return roomID, nil
+	}
+
+	var room struct {
+		RoomID string `json:"room_id"`
+	}
+	err := ma.do(ctx, resty.MethodPost, "/createRoom", map[string]interface{}{
+		"is_direct": true,
+		"invite":    []string{userID},
+		"preset":    "trusted_private_chat",
+	}, &room)
+	if err != nil {
+		return "", err
+	}
+	ma.mu.Lock()
+	ma.direct[userID] = room.RoomID
+	ma.members[room.RoomID] = 1
+	ma.mu.Unlock()
+
+	// keep the account data of other clients, then add the new room
+	if loaded {
+		if err := ma.do(ctx, resty.MethodGet, path, nil, &direct); err != nil {
+			log.Errorf("failed to read m.direct: %v", err)
+		}
+	}
+	direct[userID] = append(direct[userID], room.RoomID)
+	if err := ma.do(ctx, resty.MethodPut, path, direct, nil); err != nil {
+		log.Errorf("failed to record the direct room with %s: %v", userID, err)
+	}
+	return room.RoomID, nil
+}
+
+func (ma *MatrixAdapter) Mention(m ChatMessage) string {
+	return m.UserID
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// matrixHomeserver is the state of a fake homeserver for @fonzie:hs
+type matrixHomeserver struct {
+	// syncs are returned by the next syncs, later ones wait for new events
+	syncs []interface{}
+	// direct is the m.direct account data, nil when there is none
+	direct map[string]interface{}
+	rooms  int
+	events int
+}
+
+const mxPrefix = "/_matrix/client/v3"
+
+func newMatrixAPI(t *testing.T, hs *matrixHomeserver) *fakeAPI {
+	return newFakeAPI(t, func(c apiCall) (int, interface{}) {
+		if c.Auth != "TOKEN" {
+			return http.StatusUnauthorized, map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token"}
+		}
+		path := strings.TrimPrefix(c.Path, mxPrefix)
+		switch {
+		case path == "/account/whoami":
+			return http.StatusOK, map[string]string{"user_id": "@fonzie:hs"}
+		case path == "/sync":
+			if len(hs.syncs) == 0 {
+				return longPoll, nil
+			}
+			sync := hs.syncs[0]
+			hs.syncs = hs.syncs[1:]
+			return http.StatusOK, sync
+		case path == "/createRoom":
+			hs.rooms++
+			return http.StatusOK, map[string]string{"room_id": fmt.Sprintf("!created%d:hs", hs.rooms)}
+		case path == "/user/@fonzie:hs/account_data/m.direct" && c.Method == http.MethodGet:
+			if hs.direct == nil {
+				return http.StatusNotFound, map[string]string{"errcode": "M_NOT_FOUND", "error": "Account data not found"}
+			}
+			return http.StatusOK, hs.direct
+		case path == "/user/@fonzie:hs/account_data/m.direct":
+			hs.direct = c.Body
+		case strings.HasPrefix(path, "/rooms/"):
+			hs.events++
+			return http.StatusOK, map[string]string{"event_id": fmt.Sprintf("$ev%d", hs.events)}
+		}
+		return http.StatusOK, map[string]string{}
+	})
+}
+
+func mxMessageJSON(id string, sender string, msgType string, body string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "m.room.message",
+		"event_id": id,
+		"sender":   sender,
+		"content":  map[string]string{"msgtype": msgType, "body": body},
+	}
+}
+
+// mxRoomJSON is a joined room of a sync, members is only sent when it changed
+func mxRoomJSON(members int, events ...interface{}) map[string]interface{} {
+	room := map[string]interface{}{"timeline": map[string]interface{}{"events": events}}
+	if members > 0 {
+		room["summary"] = map[string]int{"m.joined_member_count": members}
+	}
+	return room
+}
+
+func mxSyncJSON(nextBatch string, join map[string]interface{}, invite ...string) map[string]interface{} {
+	invites := map[string]interface{}{}
+	for _, roomID := range invite {
+		invites[roomID] = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"next_batch": nextBatch,
+		"rooms":      map[string]interface{}{"join": join, "invite": invites},
+	}
+}
+
+func TestMatrixListen(t *testing.T) {
+	api := newMatrixAPI(t, &matrixHomeserver{syncs: []interface{}{
+		mxSyncJSON("s1", map[string]interface{}{
+			"!dm:hs":    mxRoomJSON(2, mxMessageJSON("$old", "@alice:hs", "m.text", "!request umee1old")),
+			"!group:hs": mxRoomJSON(5),
+		}, "!new:hs"),
+		mxSyncJSON("s2", map[string]interface{}{
+			"!dm:hs": mxRoomJSON(0,
+				mxMessageJSON("$1", "@alice:hs", "m.text", "> <@bob:hs> how do I get funds?\n\n!request umee1abc"),
+				mxMessageJSON("$2", "@fonzie:hs", "m.text", "!request umee1bot"),
+				mxMessageJSON("$3", "@alice:hs", "m.notice", "!request umee1notice"),
+			),
+		}),
+		mxSyncJSON("s3", map[string]interface{}{
+			"!group:hs": mxRoomJSON(0, mxMessageJSON("$4", "@bob:hs", "m.text", "!help")),
+		}),
+	}})
+	ma := NewMatrixAdapter(api.URL+"/", "TOKEN")
+
+	listen(t, ma, []ChatMessage{
+		{ID: "$1", ChannelID: "!dm:hs", UserID: "@alice:hs", Text: "!request umee1abc", IsDM: true},
+		{ID: "$4", ChannelID: "!group:hs", UserID: "@bob:hs", Text: "!help"},
+	}, nil)
+
+	if joins := api.called(http.MethodPost, mxPrefix+"/join/"); len(joins) != 1 || joins[0].Path != mxPrefix+"/join/!new:hs" {
+		t.Errorf("joined %v, want !new:hs", joins)
+	}
+	syncs := api.called(http.MethodGet, mxPrefix+"/sync")
+	if len(syncs) < 3 || strings.Contains(syncs[0].Query, "since") || !strings.Contains(syncs[1].Query, "since=s1") {
+		t.Errorf("synced with %v", syncs)
+	}
+}
+
+func TestMatrixUnknownToken(t *testing.T) {
+	api := newMatrixAPI(t, &matrixHomeserver{})
+	ma := NewMatrixAdapter(api.URL, "WRONG")
+	err := ma.Listen(context.Background(), func(ChatMessage) {})
+	if err == nil || !strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") {
+		t.Errorf("got %v, want the M_UNKNOWN_TOKEN error", err)
+	}
+}
+
+func TestMatrixReplyAndReactions(t *testing.T) {
+	api := newMatrixAPI(t, &matrixHomeserver{})
+	ma := NewMatrixAdapter(api.URL, "TOKEN")
+	m := ChatMessage{ID: "$1", ChannelID: "!group:hs", UserID: "@alice:hs"}
+
+	if err := ma.Reply(m, "sent **1UMEE**"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.AddReaction(m, "⚙️"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.RemoveReaction(m, "⚙️"); err != nil {
+		t.Fatal(err)
+	}
+	// never added, so there is nothing to redact
+	if err := ma.RemoveReaction(m, "✅"); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := api.called(http.MethodPut, mxPrefix+"/rooms/!group:hs/send/")
+	if len(sent) != 2 {
+		t.Fatalf("sent %v", sent)
+	}
+	reply := sent[0].Body
+	if !strings.HasPrefix(sent[0].Path, mxPrefix+"/rooms/!group:hs/send/m.room.message/") || reply["body"] != "sent 1UMEE" || reply["msgtype"] != "m.notice" {
+		t.Errorf("reply %v", sent[0])
+	}
+	if inReplyTo := reply["m.relates_to"].(map[string]interface{})["m.in_reply_to"].(map[string]interface{}); inReplyTo["event_id"] != "$1" {
+		t.Errorf("reply to %v, want $1", inReplyTo)
+	}
+	reaction := sent[1].Body["m.relates_to"].(map[string]interface{})
+	if !strings.HasPrefix(sent[1].Path, mxPrefix+"/rooms/!group:hs/send/m.reaction/") || reaction["key"] != "⚙️" || reaction["event_id"] != "$1" {
+		t.Errorf("reaction %v", sent[1])
+	}
+	// the reaction was the second event sent
+	if redacts := api.called(http.MethodPut, mxPrefix+"/rooms/!group:hs/redact/"); len(redacts) != 1 || !strings.HasPrefix(redacts[0].Path, mxPrefix+"/rooms/!group:hs/redact/$ev2/") {
+		t.Errorf("redacted %v, want $ev2", redacts)
+	}
+}
+
+func TestMatrixDirectMessage(t *testing.T) {
+	hs := &matrixHomeserver{direct: map[string]interface{}{"@carol:hs": []string{"!left:hs", "!carol:hs"}}}
+	api := newMatrixAPI(t, hs)
+	ma := NewMatrixAdapter(api.URL, "TOKEN")
+	ma.userID = "@fonzie:hs"
+	// rooms the bot is still in have a member count
+	ma.members["!carol:hs"] = 2
+
+	alice := ChatMessage{ID: "$1", ChannelID: "!group:hs", UserID: "@alice:hs"}
+	for i := 0; i < 2; i++ {
+		if err := ma.DirectMessage(alice, "hi alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ma.DirectMessage(ChatMessage{ChannelID: "!group:hs", UserID: "@carol:hs"}, "hi carol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ma.DirectMessage(ChatMessage{ChannelID: "!bob:hs", UserID: "@bob:hs", IsDM: true}, "hi bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	created := api.called(http.MethodPost, mxPrefix+"/createRoom")
+	if len(created) != 1 {
+		t.Fatalf("created %d rooms, want 1 for alice", len(created))
+	}
+	if invite := created[0].Body["invite"].([]interface{}); len(invite) != 1 || invite[0] != "@alice:hs" || created[0].Body["is_direct"] != true {
+		t.Errorf("created %v", created[0].Body)
+	}
+	for room, want := range map[string]int{"!created1:hs": 2, "!carol:hs": 1, "!bob:hs": 1} {
+		if got := len(api.called(http.MethodPut, mxPrefix+"/rooms/"+room+"/send/m.room.message/")); got != want {
+			t.Errorf("sent %d messages to %s, want %d", got, room, want)
+		}
+	}
+
+	// the new room is recorded next to the rooms of other clients
+	var direct string
+	api.locked(func() { direct = fmt.Sprint(hs.direct) })
+	if want := "map[@alice:hs:[!created1:hs] @carol:hs:[!left:hs !carol:hs]]"; direct != want {
+		t.Errorf("m.direct is %s, want %s", direct, want)
+	}
+}
+
+func TestMatrixDirectMessageWithoutAccountData(t *testing.T) {
+	hs := &matrixHomeserver{}
+	api := newMatrixAPI(t, hs)
+	ma := NewMatrixAdapter(api.URL, "TOKEN")
+	ma.userID = "@fonzie:hs"
+
+	for _, user := range []string{"@alice:hs", "@bob:hs", "@alice:hs"} {
+		if err := ma.DirectMessage(ChatMessage{ChannelID: "!group:hs", UserID: user}, "hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if created := api.called(http.MethodPost, mxPrefix+"/createRoom"); len(created) != 2 {
+		t.Errorf("created %d rooms, want one per user", len(created))
+	}
+	var direct string
+	api.locked(func() { direct = fmt.Sprint(hs.direct) })
+	if want := "map[@alice:hs:[!created1:hs] @bob:hs:[!created2:hs]]"; direct != want {
+		t.Errorf("m.direct is %s, want %s", direct, want)
+	}
+}
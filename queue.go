@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/umee-network/fonzie/chain"
+	"github.com/umee-network/fonzie/db"
+)
+
+// ResponderFactory rebuilds the Responder of a request replayed from the durable queue
+type ResponderFactory func(ref db.ReplyRef, requesterName string) Responder
+
+// RegisterResponder lets the faucet answer replayed requests of a frontend.
+// It must be called before Replay.
+func (fh FaucetHandler) RegisterResponder(frontend string, factory ResponderFactory) {
+	fh.responders[frontend] = factory
+}
+
+// Replay hands the queued requests abandoned by a previous run, or by another replica which
+// stopped renewing their lease, back to the faucet workers. Each is claimed first, so a single
+// process sends it. Requests whose funding slot was settled are only removed from the queue.
+func (fh FaucetHandler) Replay(ctx context.Context) error {
+	abandoned, err := fh.db.AbandonedRequests(ctx)
+	if err != nil {
+		return err
+	}
+	for _, q := range abandoned {
+		claimed, err := fh.db.ClaimQueuedRequest(ctx, q.ID)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if claimed == nil {
+			// completed, or claimed by another replica in the meantime
+			continue
+		}
+		if err := fh.replay(ctx, *claimed); err != nil {
+			log.Errorf("dropping queued request %s: %v", q.ID, err)
+			if err := fh.db.CompleteQueuedRequest(ctx, q.ID); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	return nil
+}
+
+// ReplayAbandoned replays the abandoned requests every lease period until ctx is done
+func (fh FaucetHandler) ReplayAbandoned(ctx context.Context) {
+	for {
+		if err := fh.Replay(ctx); err != nil && ctx.Err() == nil {
+			log.Error(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(db.QueueLease):
+		}
+	}
+}
+
+// RenewLeases keeps the queued requests of this process from being replayed elsewhere until ctx is done
+func (fh FaucetHandler) RenewLeases(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(db.QueueLease / 3):
+		}
+		if err := fh.db.RenewQueueLeases(ctx); err != nil && ctx.Err() == nil {
+			log.Error(err)
+		}
+	}
+}
+
+func (fh FaucetHandler) replay(ctx context.Context, q db.QueuedRequest) error {
+	faucet, ok := fh.faucets[q.Receipt.ChainPrefix]
+	if !ok {
+		return fmt.Errorf("%s chain prefix is not supported", q.Receipt.ChainPrefix)
+	}
+	receipt, err := fh.db.GetFundingReceiptByUsernameAndChainPrefix(ctx, q.Receipt.Username, q.Receipt.ChainPrefix)
+	if err != nil {
+		return err
+	}
+	if receipt != nil && receipt.Status != db.ReceiptPending && receipt.Status != db.ReceiptBroadcast {
+		// a pending receipt blocks the user, so a settled one means this request was processed
+		log.Infof("queued request %s was already processed", q.ID)
+		return fh.db.CompleteQueuedRequest(ctx, q.ID)
+	}
+	if receipt == nil {
+		// pruned while the faucet was down, reserve the slot again
+		if err := fh.db.SaveFundingReceipt(ctx, q.Receipt); err != nil {
+			return err
+		}
+	}
+	recipient, err := faucet.chain.DecodeAddr(q.Receipt.Recipient)
+	if err != nil {
+		return err
+	}
+
+	var responder Responder = loggedResponder{}
+	if factory, ok := fh.responders[q.Reply.Frontend]; ok {
+		responder = factory(q.Reply, q.RequesterName)
+	}
+	req := FaucetReq{
+		Recipient: recipient,
+		Coins:     q.Receipt.Amount,
+		Fees:      q.Fees,
+		Requester: Requester{ID: q.Receipt.Username, Name: q.RequesterName},
+		Responder: responder,
+		receipt:   q.Receipt,
+		queueID:   q.ID,
+	}
+	if receipt != nil && receipt.Status == db.ReceiptBroadcast {
+		// sending it again could pay the recipient twice, the tx tells what became of it
+		log.Infof("looking up tx %s of queued request %s of %s on %s", receipt.TxHash, q.ID, q.Receipt.Username, q.Receipt.ChainPrefix)
+		faucet.resolve([]FaucetReq{req}, receipt.TxHash)
+		return nil
+	}
+	log.Infof("replaying queued request %s of %s on %s", q.ID, q.Receipt.Username, q.Receipt.ChainPrefix)
+
+	// stays queued for the next start when the faucet is shutting down
+	fh.life.send(faucet, req)
+	return nil
+}
+
+// loggedResponder only logs the outcome of a replayed request whose frontend can't be reached
+type loggedResponder struct{}
+
+func (loggedResponder) Funded(r FaucetReq, tx chain.TxResult) {
+	log.Infof("replayed request of %s funded %s in %s", r.Requester.ID, r.Recipient, tx.Hash)
+}
+
+func (loggedResponder) Unconfirmed(r FaucetReq, txHash string) {
+	log.Warnf("replayed request of %s for %s broadcast in %s but not confirmed", r.Requester.ID, r.Recipient, txHash)
+}
+
+func (loggedResponder) Merged(r FaucetReq, recipient string) {
+	log.Infof("replayed request of %s for %s merged into another one for %s", r.Requester.ID, r.Recipient, recipient)
+}
+
+func (loggedResponder) Failed(r FaucetReq, err error) {
+	log.Errorf("replayed request of %s to %s failed: %v", r.Requester.ID, r.Recipient, err)
+}
+
+func (loggedResponder) ReplyRef() db.ReplyRef {
+	return db.ReplyRef{}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	cosmostypes "github.com/cosmos/cosmos-sdk/types"
+)
+
+// requiredRoles returns the roles (names or IDs) of which a member needs at least one
+// to request funding on a chain. The chain's own roles take precedence over ROLE_REQUIRED.
+func requiredRoles(prefix string) []string {
+	if roles := funding[prefix].Roles; len(roles) > 0 {
+		return roles
+	}
+	return defaultRequiredRoles
+}
+
+// guildRoles returns all roles of a guild, from the state cache when it has them
+func guildRoles(s *discordgo.Session, guildID string) ([]*discordgo.Role, error) {
+	if g, err := s.State.Guild(guildID); err == nil && len(g.Roles) > 0 {
+		return g.Roles, nil
+	}
+	return s.GuildRoles(guildID)
+}
+
+// memberRoles returns the roles held by the author of c, along with all roles of the guild
+func memberRoles(c discordCmd) (held []*discordgo.Role, all []*discordgo.Role, err error) {
+	member := c.member()
+	if member == nil {
+		member, err = c.session.GuildMember(c.guildID(), c.author().ID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	all, err = guildRoles(c.session, c.guildID())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, role := range all {
+		for _, memberRoleID := range member.Roles {
+			if role.ID == memberRoleID {
+				held = append(held, role)
+			}
+		}
+	}
+	return held, all, nil
+}
+
+func roleMatches(role *discordgo.Role, wanted string) bool {
+	return role.ID == wanted || strings.EqualFold(role.Name, wanted)
+}
+
+func hasAnyRole(held []*discordgo.Role, wanted []string) bool {
+	for _, role := range held {
+		for _, w := range wanted {
+			if roleMatches(role, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkRoles verifies the author of c has one of the required roles. Roles can only be
+// checked within a guild, so DMs are rejected whenever roles are required.
+func checkRoles(c discordCmd, prefix string) error {
+	required := requiredRoles(prefix)
+	if len(required) == 0 {
+		return nil
+	}
+	if c.isDM() {
+		return fmt.Errorf("%s funding requires a role, please send your request from the server instead of a DM", prefix)
+	}
+
+	held, all, err := memberRoles(c)
+	if err != nil {
+		return fmt.Errorf("could not verify your roles, err: %w", err)
+	}
+	if hasAnyRole(held, required) {
+		return nil
+	}
+
+	// show role names rather than IDs in the reply
+	var names []string
+	for _, wanted := range required {
+		name := wanted
+		for _, role := range all {
+			if roleMatches(role, wanted) {
+				name = role.Name
+				break
+			}
+		}
+		names = append(names, fmt.Sprintf("`%s`", name))
+	}
+	return fmt.Errorf("you need the %s role to request %s funding", strings.Join(names, " or "), prefix)
+}
+
+// resolveTier picks the funding amount and cooldown for the author of c on a chain. Tiers are
+// listed from highest to lowest, so the first tier the member holds a role for wins. Without a
+// matching tier, or outside of a guild, the chain's default coins and FUNDING_INTERVAL apply.
+func resolveTier(c discordCmd, prefix string) (cosmostypes.Coins, time.Duration, error) {
+	info := funding[prefix]
+	if len(info.Tiers) > 0 && !c.isDM() {
+		held, _, err := memberRoles(c)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not verify your roles, err: %w", err)
+		}
+		for _, tier := range info.Tiers {
+			if hasAnyRole(held, tier.Roles) {
+				return tier.parse()
+			}
+		}
+	}
+	return defaultTier(prefix)
+}
+
+// defaultTier is the chain's funding for requesters without a tier, including frontends without roles
+func defaultTier(prefix string) (cosmostypes.Coins, time.Duration, error) {
+	coins, err := cosmostypes.ParseCoinsNormalized(funding[prefix].Coins)
+	if err != nil {
+		return nil, 0, err
+	}
+	return coins, fundingInterval, nil
+}
+
+func (tier FundingTier) parse() (cosmostypes.Coins, time.Duration, error) {
+	coins, err := cosmostypes.ParseCoinsNormalized(tier.Coins)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tier.Interval == "" {
+		return coins, fundingInterval, nil
+	}
+	interval, err := time.ParseDuration(tier.Interval)
+	if err != nil {
+		return nil, 0, err
+	}
+	return coins, interval, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/umee-network/fonzie/db"
+)
+
+// errShuttingDown is reported for requests the faucet can no longer process
+var errShuttingDown = errors.New("the faucet is shutting down, please try again later")
+
+// lifecycle tracks whether the faucet workers still accept requests
+type lifecycle struct {
+	mu      sync.RWMutex
+	closing bool
+	quit    chan bool
+	workers sync.WaitGroup
+	// abort is cancelled when the drain deadline passed, so workers stop retrying
+	abort  context.Context
+	cancel context.CancelFunc
+}
+
+func newLifecycle() *lifecycle {
+	abort, cancel := context.WithCancel(context.Background())
+	return &lifecycle{quit: make(chan bool), abort: abort, cancel: cancel}
+}
+
+func (l *lifecycle) isClosing() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.closing
+}
+
+// send hands req to the faucet worker, or returns false once the workers were told to quit.
+// The lock isn't held while waiting, a busy worker must not delay Shutdown.
+func (l *lifecycle) send(faucet ChainFaucet, req FaucetReq) bool {
+	select {
+	case faucet.channel <- req:
+		return true
+	case <-l.quit:
+		return false
+	}
+}
+
+// enqueue records req in the durable queue and hands it to the faucet worker, unless the faucet is
+// shutting down. A refused request's funding slot is released so the requester can try again later.
+func (fh FaucetHandler) enqueue(faucet ChainFaucet, req FaucetReq) error {
+	if fh.life.isClosing() {
+		fh.release(req)
+		return errShuttingDown
+	}
+	id, err := fh.db.QueueRequest(context.Background(), db.QueuedRequest{
+		Receipt:       req.receipt,
+		Fees:          req.Fees,
+		RequesterName: req.Requester.Name,
+		Reply:         req.Responder.ReplyRef(),
+	})
+	if err != nil {
+		log.Error(err)
+		fh.release(req)
+		return fmt.Errorf("could not queue %s funding, please try again later", faucet.chain.Prefix)
+	}
+	req.queueID = id
+	if !fh.life.send(faucet, req) {
+		fh.release(req)
+		if err := fh.db.CompleteQueuedRequest(context.Background(), id); err != nil {
+			log.Error(err)
+		}
+		return errShuttingDown
+	}
+	return nil
+}
+
+// release frees the funding slot of a request which won't be processed
+func (fh FaucetHandler) release(req FaucetReq) {
+	if err := fh.db.FailFundingSlot(context.Background(), req.receipt, ""); err != nil {
+		log.Error(err)
+	}
+}
+
+// Shutdown stops accepting requests and lets every worker send its pending batch.
+// Once ctx is done the workers stop retrying and fail what is left, after which
+// Shutdown gives them grace to finish a broadcast in flight.
+func (fh FaucetHandler) Shutdown(ctx context.Context, grace time.Duration) error {
+	fh.life.mu.Lock()
+	if fh.life.closing {
+		fh.life.mu.Unlock()
+		return nil
+	}
+	// nothing is sent to the workers past this point, senders waiting on a worker give up on quit
+	fh.life.closing = true
+	fh.life.mu.Unlock()
+	close(fh.life.quit)
+
+	done := make(chan struct{})
+	go func() {
+		fh.life.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Warn("faucet workers didn't drain in time, failing the remaining requests")
+	fh.life.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(grace):
+		return errors.New("faucet workers didn't stop")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// slackReactions maps the reactions the faucet uses to Slack emoji names
+var slackReactions = map[string]string{
+	"👍":  "thumbsup",
+	"⚙️": "gear",
+	"✅":  "white_check_mark",
+	"❌":  "x",
+	"⏳":  "hourglass_flowing_sand",
+	"🔁":  "repeat",
+}
+
+type (
+	slackResponse struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	slackEnvelope struct {
+		EnvelopeID string `json:"envelope_id"`
+		Type       string `json:"type"`
+		Payload    struct {
+			Event slackEvent `json:"event"`
+		} `json:"payload"`
+	}
+	slackEvent struct {
+		Type        string `json:"type"`
+		Subtype     string `json:"subtype"`
+		Channel     string `json:"channel"`
+		ChannelType string `json:"channel_type"`
+		User        string `json:"user"`
+		BotID       string `json:"bot_id"`
+		Text        string `json:"text"`
+		TS          string `json:"ts"`
+	}
+)
+
+// SlackAdapter is a Slack chat frontend receiving events over Socket Mode, so no public endpoint is needed
+type SlackAdapter struct {
+	client   *resty.Client
+	appToken string
+	botToken string
+}
+
+// NewSlackAdapter talks to the Web API at apiURL, normally https://slack.com/api.
+// appToken opens Socket Mode connections, botToken posts messages.
+func NewSlackAdapter(apiURL string, appToken string, botToken string) *SlackAdapter {
+	return &SlackAdapter{
+		client: resty.New().
+			SetBaseURL(strings.TrimRight(apiURL, "/")).
+			SetTimeout(10 * time.Second),
+		appToken: appToken,
+		botToken: botToken,
+	}
+}
+
+func (sa *SlackAdapter) Name() string {
+	return "slack"
+}
+
+func (sa *SlackAdapter) IDPrefix() string {
+	return "slack"
+}
+
+// call posts a Web API method and decodes the response into result
+func (sa *SlackAdapter) call(ctx context.Context, token string, method string, body interface{}, result interface{}) error {
+	var status slackResponse
+	resp, err := sa.client.R().
+		SetContext(ctx).
+		SetAuthToken(token).
+		SetBody(body).
+		SetResult(&status).
+		ForceContentType("application/json").
+		Post("/" + method)
+	if err != nil {
+		return err
+	}
+	if !status.OK {
+		return fmt.Errorf("slack %s failed; http code %d: %s", method, resp.StatusCode(), status.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(resp.Body(), result)
+	}
+	return nil
+}
+
+// Listen reads Socket Mode events until ctx is done, reconnecting when Slack asks to
+func (sa *SlackAdapter) Listen(ctx context.Context, handle func(ChatMessage)) error {
+	for ctx.Err() == nil {
+		err := sa.listenOnce(ctx, handle)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Error(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+	return nil
+}
+
+func (sa *SlackAdapter) listenOnce(ctx context.Context, handle func(ChatMessage)) error {
+	var conn struct {
+		URL string `json:"url"`
+	}
+	if err := sa.call(ctx, sa.appToken, "apps.connections.open", map[string]string{}, &conn); err != nil {
+		return err
+	}
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, conn.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	for {
+		var env slackEnvelope
+		if err := ws.ReadJSON(&env); err != nil {
+			return err
+		}
+		if env.EnvelopeID != "" {
+			if err := ws.WriteJSON(map[string]string{"envelope_id": env.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+		switch env.Type {
+		case "disconnect":
+			return nil
+		case "events_api":
+			ev := env.Payload.Event
+			if ev.Type != "message" || ev.Subtype != "" {
+				continue
+			}
+			handle(ChatMessage{
+				ID:        ev.TS,
+				ChannelID: ev.Channel,
+				UserID:    ev.User,
+				Text:      ev.Text,
+				IsDM:      ev.ChannelType == "im",
+				IsBot:     ev.BotID != "",
+			})
+		}
+	}
+}
+
+func (sa *SlackAdapter) post(channel string, threadTS string, text string) error {
+	body := map[string]string{
+		"channel": channel,
+		// Slack bolds with single asterisks
+		"text": strings.ReplaceAll(text, "**", "*"),
+	}
+	if threadTS != "" {
+		body["thread_ts"] = threadTS
+	}
+	return sa.call(context.Background(), sa.botToken, "chat.postMessage", body, nil)
+}
+
+// Reply answers in a thread on m
+func (sa *SlackAdapter) Reply(m ChatMessage, text string) error {
+	return sa.post(m.ChannelID, m.ID, text)
+}
+
+func (sa *SlackAdapter) reaction(method string, m ChatMessage, reaction string) error {
+	name, ok := slackReactions[reaction]
+	if !ok {
+		return fmt.Errorf("no slack emoji for reaction %s", reaction)
+	}
+	return sa.call(context.Background(), sa.botToken, method, map[string]string{
+		"channel":   m.ChannelID,
+		"timestamp": m.ID,
+		"name":      name,
+	}, nil)
+}
+
+func (sa *SlackAdapter) AddReaction(m ChatMessage, reaction string) error {
+	return sa.reaction("reactions.add", m, reaction)
+}
+
+func (sa *SlackAdapter) RemoveReaction(m ChatMessage, reaction string) error {
+	return sa.reaction("reactions.remove", m, reaction)
+}
+
+func (sa *SlackAdapter) DirectMessage(m ChatMessage, text string) error {
+	var conv struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	err := sa.call(context.Background(), sa.botToken, "conversations.open", map[string]string{"users": m.UserID}, &conv)
+	if err != nil {
+		return err
+	}
+	return sa.post(conv.Channel.ID, "", text)
+}
+
+func (sa *SlackAdapter) Mention(m ChatMessage) string {
+	return fmt.Sprintf("<@%s>", m.UserID)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newSlackAPI fakes the Web API for the xapp and xoxb tokens, and a Socket Mode endpoint on /ws
+// sending envelopes on the first connection and the envelope IDs it acknowledges to acks
+func newSlackAPI(t *testing.T, envelopes []interface{}, acks chan<- string) *fakeAPI {
+	var api *fakeAPI
+	api = newFakeAPI(t, func(c apiCall) (int, interface{}) {
+		// Slack answers errors with 200
+		switch {
+		case c.Path == "/apps.connections.open" && c.Auth == "xapp":
+			return http.StatusOK, map[string]interface{}{"ok": true, "url": "ws" + strings.TrimPrefix(api.URL, "http") + "/ws"}
+		case c.Auth != "xoxb":
+			return http.StatusOK, map[string]interface{}{"ok": false, "error": "invalid_auth"}
+		case c.Path == "/conversations.open":
+			return http.StatusOK, map[string]interface{}{"ok": true, "channel": map[string]interface{}{"id": "D" + c.Body["users"].(string)}}
+		case c.Path == "/chat.postMessage" && c.Body["channel"] == "gone":
+			return http.StatusOK, map[string]interface{}{"ok": false, "error": "channel_not_found"}
+		}
+		return http.StatusOK, map[string]interface{}{"ok": true}
+	})
+
+	upgrader := websocket.Upgrader{}
+	api.Mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+		var sending []interface{}
+		api.locked(func() {
+			sending, envelopes = envelopes, nil
+		})
+		for _, env := range sending {
+			if err := ws.WriteJSON(env); err != nil {
+				return
+			}
+		}
+		for {
+			var ack struct {
+				EnvelopeID string `json:"envelope_id"`
+			}
+			if err := ws.ReadJSON(&ack); err != nil {
+				return
+			}
+			acks <- ack.EnvelopeID
+		}
+	})
+	return api
+}
+
+func slackMessageJSON(envelopeID string, event map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"envelope_id": envelopeID,
+		"type":        "events_api",
+		"payload":     map[string]interface{}{"event": event},
+	}
+}
+
+func TestSlackListen(t *testing.T) {
+	acks := make(chan string, 10)
+	api := newSlackAPI(t, []interface{}{
+		map[string]string{"type": "hello"},
+		slackMessageJSON("e1", map[string]string{"type": "message", "channel": "C1", "channel_type": "channel", "user": "U1", "text": "!request umee1abc", "ts": "1.1"}),
+		// edits and joins have a subtype
+		slackMessageJSON("e2", map[string]string{"type": "message", "subtype": "message_changed", "channel": "C1", "ts": "1.2"}),
+		slackMessageJSON("e3", map[string]string{"type": "message", "channel": "D1", "channel_type": "im", "user": "U2", "bot_id": "B1", "text": "!help", "ts": "1.3"}),
+		map[string]string{"type": "disconnect"},
+	}, acks)
+	sa := NewSlackAdapter(api.URL, "xapp", "xoxb")
+
+	listen(t, sa, []ChatMessage{
+		{ID: "1.1", ChannelID: "C1", UserID: "U1", Text: "!request umee1abc"},
+		{ID: "1.3", ChannelID: "D1", UserID: "U2", Text: "!help", IsDM: true, IsBot: true},
+	}, func() bool {
+		// the disconnect envelope opens a new connection
+		return len(api.called(http.MethodPost, "/apps.connections.open")) >= 2
+	})
+
+	for _, id := range []string{"e1", "e2", "e3"} {
+		if ack := <-acks; ack != id {
+			t.Errorf("acknowledged %s, want %s", ack, id)
+		}
+	}
+}
+
+func TestSlackReply(t *testing.T) {
+	api := newSlackAPI(t, nil, nil)
+	sa := NewSlackAdapter(api.URL, "xapp", "xoxb")
+	m := ChatMessage{ID: "1.1", ChannelID: "C1", UserID: "U1"}
+
+	if err := sa.Reply(m, "sent **1UMEE**"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.DirectMessage(m, "hi"); err != nil {
+		t.Fatal(err)
+	}
+	posted := api.called(http.MethodPost, "/chat.postMessage")
+	if len(posted) != 2 {
+		t.Fatalf("posted %v", posted)
+	}
+	if want := map[string]interface{}{"channel": "C1", "thread_ts": "1.1", "text": "sent *1UMEE*"}; !equalBodies(posted[0].Body, want) {
+		t.Errorf("reply %v, want %v", posted[0].Body, want)
+	}
+	if want := map[string]interface{}{"channel": "DU1", "text": "hi"}; !equalBodies(posted[1].Body, want) {
+		t.Errorf("direct message %v, want %v", posted[1].Body, want)
+	}
+
+	if err := sa.Reply(ChatMessage{ChannelID: "gone"}, "hi"); err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("got %v, want the channel_not_found error", err)
+	}
+}
+
+func TestSlackReactions(t *testing.T) {
+	api := newSlackAPI(t, nil, nil)
+	sa := NewSlackAdapter(api.URL, "xapp", "xoxb")
+	m := ChatMessage{ID: "1.1", ChannelID: "C1"}
+
+	if err := sa.AddReaction(m, "🔁"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.RemoveReaction(m, "⚙️"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.AddReaction(m, "🦄"); err == nil {
+		t.Error("no error for a reaction without a slack emoji")
+	}
+	for method, name := range map[string]string{"/reactions.add": "repeat", "/reactions.remove": "gear"} {
+		calls := api.called(http.MethodPost, method)
+		want := map[string]interface{}{"channel": "C1", "timestamp": "1.1", "name": name}
+		if len(calls) != 1 || !equalBodies(calls[0].Body, want) {
+			t.Errorf("%s %v, want %v", method, calls, want)
+		}
+	}
+}
+
+func equalBodies(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
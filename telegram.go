@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// how long a getUpdates call waits for new messages
+const telegramPollTimeout = 30 * time.Second
+
+type (
+	tgResponse struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	tgUpdates struct {
+		tgResponse
+		Result []tgUpdate `json:"result"`
+	}
+	tgUpdate struct {
+		UpdateID int64      `json:"update_id"`
+		Message  *tgMessage `json:"message"`
+	}
+	tgMessage struct {
+		MessageID int64   `json:"message_id"`
+		From      *tgUser `json:"from"`
+		Chat      tgChat  `json:"chat"`
+		Text      string  `json:"text"`
+	}
+	tgUser struct {
+		ID        int64  `json:"id"`
+		IsBot     bool   `json:"is_bot"`
+		FirstName string `json:"first_name"`
+		Username  string `json:"username"`
+	}
+	tgChat struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"`
+	}
+)
+
+// tgReactions maps the reactions of the faucet to the emoji bots may react with on Telegram
+var tgReactions = map[string]string{
+	"👍":  "👍",
+	"⚙️": "👨‍💻",
+	"✅":  "🎉",
+	"❌":  "👎",
+	"⏳":  "👀",
+	"🔁":  "👌",
+}
+
+// TelegramAdapter is a long-polling Telegram chat frontend. Telegram commands start with `/`, so
+// they are passed on in the `!` form of the other platforms.
+type TelegramAdapter struct {
+	client *resty.Client
+	offset int64
+}
+
+// NewTelegramAdapter talks to the Bot API at apiURL, normally https://api.telegram.org
+func NewTelegramAdapter(token string, apiURL string) *TelegramAdapter {
+	return &TelegramAdapter{
+		client: resty.New().
+			SetBaseURL(strings.TrimRight(apiURL, "/") + "/bot" + token).
+			SetTimeout(telegramPollTimeout + 10*time.Second),
+	}
+}
+
+func (ta *TelegramAdapter) Name() string {
+	return "telegram"
+}
+
+// IDPrefix keeps the `tg:` requester IDs the receipts of the Telegram bot were stored under
+func (ta *TelegramAdapter) IDPrefix() string {
+	return "tg"
+}
+
+// call posts a Bot API method and decodes the response into result
+func (ta *TelegramAdapter) call(ctx context.Context, method string, body interface{}, result interface{}) error {
+	var res tgResponse
+	if result == nil {
+		result = &res
+	}
+	resp, err := ta.client.R().
+		SetContext(ctx).
+		SetBody(body).
+		SetResult(result).
+		SetError(&res).
+		ForceContentType("application/json").
+		Post("/" + method)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("telegram %s failed; http code %d: %s", method, resp.StatusCode(), res.Description)
+	}
+	return nil
+}
+
+// Listen polls for messages until ctx is done
+func (ta *TelegramAdapter) Listen(ctx context.Context, handle func(ChatMessage)) error {
+	for ctx.Err() == nil {
+		var updates tgUpdates
+		err := ta.call(ctx, "getUpdates", map[string]interface{}{
+			"offset":          ta.offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil && !updates.OK {
+			err = fmt.Errorf("telegram getUpdates failed: %s", updates.Description)
+		}
+		if err != nil {
+			log.Error(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		for _, u := range updates.Result {
+			ta.offset = u.UpdateID + 1
+			if u.Message == nil || u.Message.From == nil {
+				continue
+			}
+			m := u.Message
+			name := m.From.Username
+			if name == "" {
+				name = m.From.FirstName
+			}
+			handle(ChatMessage{
+				ID:        strconv.FormatInt(m.MessageID, 10),
+				ChannelID: strconv.FormatInt(m.Chat.ID, 10),
+				UserID:    strconv.FormatInt(m.From.ID, 10),
+				UserName:  name,
+				Text:      tgCommand(m.Text),
+				IsDM:      m.Chat.Type == "private",
+				IsBot:     m.From.IsBot,
+			})
+		}
+	}
+	return nil
+}
+
+// tgCommand rewrites a leading `/request@fonzie_bot` command, as addressed in groups, to `!request`
+func tgCommand(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return text
+	}
+	cmd, args, _ := strings.Cut(text, " ")
+	cmd = strings.SplitN(cmd, "@", 2)[0]
+	return strings.TrimSpace("!" + strings.TrimPrefix(cmd, "/") + " " + args)
+}
+
+func (ta *TelegramAdapter) send(chatID string, replyTo string, text string) error {
+	body := map[string]interface{}{
+		"chat_id": chatID,
+		// Telegram shows Discord's **bold** markers literally, so drop them
+		"text":                     strings.ReplaceAll(text, "**", ""),
+		"disable_web_page_preview": true,
+	}
+	if replyTo != "" {
+		messageID, err := strconv.ParseInt(replyTo, 10, 64)
+		if err != nil {
+			return err
+		}
+		body["reply_to_message_id"] = messageID
+		body["allow_sending_without_reply"] = true
+	}
+	return ta.call(context.Background(), "sendMessage", body, nil)
+}
+
+func (ta *TelegramAdapter) Reply(m ChatMessage, text string) error {
+	return ta.send(m.ChannelID, m.ID, text)
+}
+
+// AddReaction sets the reaction of the bot on m. Bots get a single reaction per message, so it
+// replaces the previous one.
+func (ta *TelegramAdapter) AddReaction(m ChatMessage, reaction string) error {
+	emoji, ok := tgReactions[reaction]
+	if !ok || m.ID == "" {
+		return nil
+	}
+	messageID, err := strconv.ParseInt(m.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	return ta.call(context.Background(), "setMessageReaction", map[string]interface{}{
+		"chat_id":    m.ChannelID,
+		"message_id": messageID,
+		"reaction":   []map[string]string{{"type": "emoji", "emoji": emoji}},
+	}, nil)
+}
+
+// RemoveReaction does nothing, the next reaction replaces the current one
+func (ta *TelegramAdapter) RemoveReaction(m ChatMessage, reaction string) error {
+	return nil
+}
+
+// DirectMessage sends text to the private chat of the author, which needs them to have started the bot
+func (ta *TelegramAdapter) DirectMessage(m ChatMessage, text string) error {
+	return ta.send(m.UserID, "", text)
+}
+
+func (ta *TelegramAdapter) Mention(m ChatMessage) string {
+	if m.UserName == "" {
+		return m.UserID
+	}
+	return m.UserName
+}
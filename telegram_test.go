@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTelegramAPI fakes the Bot API for the TOKEN bot. updates are returned by the first getUpdates,
+// later ones wait for new messages.
+func newTelegramAPI(t *testing.T, updates ...map[string]interface{}) *fakeAPI {
+	return newFakeAPI(t, func(c apiCall) (int, interface{}) {
+		if !strings.HasPrefix(c.Path, "/botTOKEN/") {
+			return http.StatusUnauthorized, map[string]interface{}{"ok": false, "description": "Unauthorized"}
+		}
+		if c.Path == "/botTOKEN/getUpdates" {
+			if updates == nil {
+				return longPoll, nil
+			}
+			result := updates
+			updates = nil
+			return http.StatusOK, map[string]interface{}{"ok": true, "result": result}
+		}
+		return http.StatusOK, map[string]interface{}{"ok": true, "result": true}
+	})
+}
+
+func tgUpdateJSON(updateID int, message map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"update_id": updateID, "message": message}
+}
+
+func TestTelegramListen(t *testing.T) {
+	api := newTelegramAPI(t,
+		tgUpdateJSON(7, map[string]interface{}{
+			"message_id": 10,
+			"from":       map[string]interface{}{"id": 42, "first_name": "Alice", "username": "alice"},
+			"chat":       map[string]interface{}{"id": -100, "type": "supergroup"},
+			"text":       "/request@fonzie_bot umee1abc",
+		}),
+		// channel posts have no author
+		tgUpdateJSON(8, map[string]interface{}{
+			"message_id": 11,
+			"chat":       map[string]interface{}{"id": -200, "type": "channel"},
+			"text":       "hello",
+		}),
+		tgUpdateJSON(9, map[string]interface{}{
+			"message_id": 12,
+			"from":       map[string]interface{}{"id": 43, "first_name": "Bob", "is_bot": true},
+			"chat":       map[string]interface{}{"id": 43, "type": "private"},
+			"text":       "!help",
+		}),
+	)
+	ta := NewTelegramAdapter("TOKEN", api.URL+"/")
+
+	listen(t, ta, []ChatMessage{
+		{ID: "10", ChannelID: "-100", UserID: "42", UserName: "alice", Text: "!request umee1abc"},
+		{ID: "12", ChannelID: "43", UserID: "43", UserName: "Bob", Text: "!help", IsDM: true, IsBot: true},
+	}, func() bool {
+		// the next poll acknowledges the updates
+		return len(api.called(http.MethodPost, "/botTOKEN/getUpdates")) >= 2
+	})
+
+	polls := api.called(http.MethodPost, "/botTOKEN/getUpdates")
+	if polls[0].Body["offset"] != float64(0) || polls[1].Body["offset"] != float64(10) {
+		t.Errorf("polled from %v then %v, want 0 then 10", polls[0].Body["offset"], polls[1].Body["offset"])
+	}
+}
+
+func TestTelegramReply(t *testing.T) {
+	api := newTelegramAPI(t)
+	ta := NewTelegramAdapter("TOKEN", api.URL)
+	m := ChatMessage{ID: "10", ChannelID: "-100", UserID: "42"}
+
+	if err := ta.Reply(m, "sent **1UMEE**"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.DirectMessage(m, "hi"); err != nil {
+		t.Fatal(err)
+	}
+	sent := api.called(http.MethodPost, "/botTOKEN/sendMessage")
+	if len(sent) != 2 {
+		t.Fatalf("sent %v", sent)
+	}
+	if b := sent[0].Body; b["chat_id"] != "-100" || b["reply_to_message_id"] != float64(10) || b["text"] != "sent 1UMEE" {
+		t.Errorf("reply %v", b)
+	}
+	if b := sent[1].Body; b["chat_id"] != "42" || b["reply_to_message_id"] != nil {
+		t.Errorf("direct message %v", b)
+	}
+
+	bad := NewTelegramAdapter("WRONG", api.URL)
+	if err := bad.Reply(m, "hi"); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("got %v, want the Unauthorized error", err)
+	}
+}
+
+func TestTelegramReactions(t *testing.T) {
+	api := newTelegramAPI(t)
+	ta := NewTelegramAdapter("TOKEN", api.URL)
+	m := ChatMessage{ID: "10", ChannelID: "-100"}
+
+	for _, reaction := range []string{"⚙️", "🔁"} {
+		if err := ta.AddReaction(m, reaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// removing is left to the next reaction, unknown ones are skipped
+	if err := ta.RemoveReaction(m, "⚙️"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.AddReaction(m, "🦄"); err != nil {
+		t.Fatal(err)
+	}
+
+	set := api.called(http.MethodPost, "/botTOKEN/setMessageReaction")
+	if len(set) != 2 {
+		t.Fatalf("reactions %v", set)
+	}
+	for i, emoji := range []string{"👨‍💻", "👌"} {
+		reactions := set[i].Body["reaction"].([]interface{})
+		if set[i].Body["message_id"] != float64(10) || reactions[0].(map[string]interface{})["emoji"] != emoji {
+			t.Errorf("reaction %v, want %s", set[i].Body, emoji)
+		}
+	}
+}
+
+func TestTgCommand(t *testing.T) {
+	for text, want := range map[string]string{
+		"/request umee1abc":            "!request umee1abc",
+		"/request@fonzie_bot umee1abc": "!request umee1abc",
+		"/help@fonzie_bot":             "!help",
+		"/status":                      "!status",
+		"!request umee1abc":            "!request umee1abc",
+		"thanks /request was fast":     "thanks /request was fast",
+	} {
+		if got := tgCommand(text); got != want {
+			t.Errorf("tgCommand(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestTelegramIDPrefix(t *testing.T) {
+	// receipts of Telegram users are stored under tg: IDs
+	if got := NewTelegramAdapter("TOKEN", "").IDPrefix(); got != "tg" {
+		t.Errorf("IDPrefix() = %q, want tg", got)
+	}
+}
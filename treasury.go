@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/umee-network/fonzie/chain"
+)
+
+// adminChannel posts notices for the faucet operators to a Discord channel and a webhook, when configured
+type adminChannel struct {
+	session   *discordgo.Session
+	channelID string
+	// webhookURL receives the notices as a Discord webhook message, `{"content": "..."}`
+	webhookURL string
+	client     *resty.Client
+}
+
+func newAdminChannel(session *discordgo.Session, channelID string, webhookURL string) adminChannel {
+	return adminChannel{
+		session:    session,
+		channelID:  channelID,
+		webhookURL: webhookURL,
+		client:     resty.New().SetTimeout(10 * time.Second),
+	}
+}
+
+// announce logs msg and posts it to the admin channel and webhook
+func (a adminChannel) announce(msg string) {
+	log.Info(msg)
+	if a.channelID != "" {
+		if _, err := a.session.ChannelMessageSend(a.channelID, msg); err != nil {
+			log.Errorf("failed to post to the admin channel: %v", err)
+		}
+	}
+	if a.webhookURL != "" {
+		resp, err := a.client.R().SetBody(map[string]string{"content": msg}).Post(a.webhookURL)
+		if err == nil && resp.IsError() {
+			err = fmt.Errorf("status %s", resp.Status())
+		}
+		if err != nil {
+			log.Errorf("failed to post to the admin webhook: %v", err)
+		}
+	}
+}
+
+// watchTreasury checks the faucet balances of c every top-up interval and refills the accounts
+// below the threshold from the treasury, until ctx is done
+func watchTreasury(ctx context.Context, c *chain.Chain, admin adminChannel) {
+	interval, err := c.TopUpInterval()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	treasury, err := c.TreasuryAddr()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	log.Infof("watching the %s faucet balances every %s, refilling from %s", c.Prefix, interval, treasury)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := map[string]bool{}
+	for {
+		refills, err := c.RefillAccounts(ctx)
+		if err != nil {
+			log.Errorf("failed to check the %s faucet balances: %v", c.Prefix, err)
+		}
+		for _, refill := range refills {
+			if !refillChanged(failing, refill) {
+				log.Errorf("failed again to refill %s faucet account %s: %v", c.Prefix, refill.Address, refill.Err)
+				continue
+			}
+			admin.announce(refillNotice(c, treasury, refill))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refillChanged records if the refill of an account failed and tells if it is worth announcing:
+// every refill sent is, a failure only when the previous refill of the account didn't fail
+func refillChanged(failing map[string]bool, refill chain.Refill) bool {
+	failed := refill.Err != nil
+	previous := failing[refill.Address]
+	failing[refill.Address] = failed
+	return !failed || !previous
+}
+
+func refillNotice(c *chain.Chain, treasury string, refill chain.Refill) string {
+	coins := ChainFaucet{chain: c}.formatCoins(refill.Coins)
+	if refill.Err != nil {
+		return fmt.Sprintf("❌ Failed to refill %s faucet account `%s` with %s from treasury `%s`: %v", c.Prefix, refill.Address, coins, treasury, refill.Err)
+	}
+	return fmt.Sprintf("💰 Refilled %s faucet account `%s` with %s from treasury `%s`\n%s/%s", c.Prefix, refill.Address, coins, treasury, finderURL(), refill.Tx.Hash)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/umee-network/fonzie/chain"
+)
+
+func TestRefillChanged(t *testing.T) {
+	failing := map[string]bool{}
+	empty := errors.New("insufficient funds")
+	steps := []struct {
+		refill   chain.Refill
+		announce bool
+	}{
+		{chain.Refill{Address: "a", Err: empty}, true},
+		{chain.Refill{Address: "a", Err: empty}, false},
+		// other accounts fail on their own
+		{chain.Refill{Address: "b", Err: empty}, true},
+		{chain.Refill{Address: "a", Err: empty}, false},
+		{chain.Refill{Address: "a", Tx: chain.TxResult{Hash: "ABC"}}, true},
+		{chain.Refill{Address: "a", Tx: chain.TxResult{Hash: "DEF"}}, true},
+		{chain.Refill{Address: "a", Err: empty}, true},
+	}
+	for i, s := range steps {
+		if got := refillChanged(failing, s.refill); got != s.announce {
+			t.Errorf("step %d: announced %v, want %v", i, got, s.announce)
+		}
+	}
+}
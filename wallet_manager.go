@@ -1,167 +1,395 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/Entrio/subenv"
-	"github.com/bwmarrin/discordgo"
 	"github.com/cosmos/cosmos-sdk/types"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/umee-network/fonzie/chain"
+	"github.com/umee-network/fonzie/db"
 )
 
 /*
  * 1. create a worker -> a go routine which will consume a channel
  * 2. the worker will wait for new requests and have a time guard for processing faucet requests
- *   - we will batch requests in 4s, max 50 requests per transactions
+ *   - we will batch requests for the chain's batch_interval, max_batch requests per transaction
+ *   - a batch is split into several transactions when it would use more gas than the chain allows
  *
  */
 
 type (
+	// Requester identifies who asked for funding. The ID keys the cooldown receipts and is namespaced
+	// by frontend (e.g. `ip:`), except for Discord user IDs which are kept as-is for existing receipts.
+	Requester struct {
+		ID   string
+		Name string
+	}
+	// Responder is told the outcome of a request once the faucet worker processed it.
+	// Every frontend implements it to reply in its own way.
+	Responder interface {
+		Funded(r FaucetReq, tx chain.TxResult)
+		// Unconfirmed is told when the tx was broadcast but not seen in a block in time
+		Unconfirmed(r FaucetReq, txHash string)
+		// Merged is told when r was dropped for another request of the same batch, paying recipient instead
+		Merged(r FaucetReq, recipient string)
+		Failed(r FaucetReq, err error)
+		// ReplyRef is stored with the queued request to answer it after a restart
+		ReplyRef() db.ReplyRef
+	}
 	FaucetReq struct {
 		Recipient types.AccAddress
 		Coins     types.Coins
 		Fees      types.Coins
-		session   *discordgo.Session
-		msg       *discordgo.MessageCreate
+		Requester Requester
+		Responder Responder
+		receipt   db.FundingReceipt
+		// queueID is the request's entry in the durable queue, removed once it was processed
+		queueID string
 	}
 	StatusReq struct {
-		session *discordgo.Session
-		msg     *discordgo.MessageCreate
+		report chan<- string
 	}
 )
 
-type BalanceResponse struct {
-	Balances []struct {
-		Denom  string `json:"denom"`
-		Amount string `json:"amount"`
-	} `json:"balances"`
-	Pagination struct {
-		NextKey string `json:"next_key"`
-		Total   string `json:"total"`
-	} `json:"pagination"`
-}
-
 type ChainFaucet struct {
 	channel chan FaucetReq
 	status  chan StatusReq
 	chain   *chain.Chain
+	db      *db.Db
+	// abort is cancelled when the faucet shuts down and can't wait for retries anymore
+	abort context.Context
+	// send replaces the multi-send on the chain when set
+	send func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult)
+	// findTx replaces the tx lookup on the chain when set
+	findTx func(txHash string) (error, chain.TxResult)
+	// estimator replaces the chain when splitting batches by gas, when set
+	estimator gasEstimator
 }
 
-func (br BalanceResponse) getBalance() string {
-	for _, d := range br.Balances {
-		if d.Denom == subenv.Env("BALANCE_DENOM", "uandr") {
-			famt, err := strconv.ParseFloat(d.Amount, 64)
-			if err != nil {
-				return "error"
-			}
-			return fmt.Sprintf("%f", famt/1000000)
-		}
-	}
-	return "NaN"
+// gasEstimator tells how much gas a tx may use and a multi-send needs
+type gasEstimator interface {
+	GasLimit(ctx context.Context) (uint64, error)
+	EstimateMultiSend(toAddr []types.AccAddress, coins []types.Coins) (uint64, error)
 }
 
-func (cf ChainFaucet) Consume(quit chan bool) {
+// Consume batches requests until quit is closed, then sends the pending batch and marks itself done
+func (cf ChainFaucet) Consume(quit chan bool, done *sync.WaitGroup) {
+	defer done.Done()
 	log.Info("starting worker ", cf.chain.Prefix)
 	var r FaucetReq
 	var rs []FaucetReq
-	const interval = time.Second * 1
+	interval, maxBatch, err := cf.chain.Batching()
+	if err != nil {
+		log.Fatal(err)
+	}
 	var t = time.NewTicker(interval)
+	// batches are broadcast back to back while earlier ones wait to be committed
+	var p = newTxPipeline(maxInFlightTxs * cf.chain.AccountCount())
 
 	for {
 		select {
 		case r = <-cf.channel:
 			log.Infof("%s worker NEW request, req: %v", cf.chain.Prefix, r)
 			rs = append(rs, r)
-			if len(rs) > 160 { // Maximum of 160 wallets in single multisend
-				cf.processRequests(rs)
+			if len(rs) >= maxBatch {
+				cf.processRequests(rs, p)
 				rs = make([]FaucetReq, 0)
 				t.Reset(interval)
 			} else {
 				log.Infof("%s worker waiting for more requests, %v", cf.chain.Prefix, r)
 			}
 		case sr := <-cf.status:
-			cf.processStatusRequests(sr)
+			sr.report <- cf.statusReport()
 		case <-t.C:
 			if len(rs) > 0 {
-				cf.processRequests(rs)
+				cf.processRequests(rs, p)
 				rs = make([]FaucetReq, 0)
 			}
 
 		case <-quit:
+			t.Stop()
 			if len(rs) > 0 {
-				cf.processRequests(rs)
+				cf.processRequests(rs, p)
 			}
-			// die so kubernetes restarts pod
-			log.Fatal("Worker ", cf.chain.Prefix, " quit")
+			p.wait()
+			log.Info("Worker ", cf.chain.Prefix, " quit")
+			return
 		}
 	}
 }
 
-func (cf ChainFaucet) processStatusRequests(sr StatusReq) {
-	c := cf.chain.GetClient()
-	faucetRawAddr, err := c.GetKeyAddress()
+// txPipeline bounds the batches of a worker which are being sent at once
+type txPipeline struct {
+	slots   chan struct{}
+	pending sync.WaitGroup
+}
+
+func newTxPipeline(size int) *txPipeline {
+	return &txPipeline{slots: make(chan struct{}, size)}
+}
+
+// run calls send in the background once a slot is free
+func (p *txPipeline) run(send func()) {
+	p.slots <- struct{}{}
+	p.pending.Add(1)
+	go func() {
+		defer func() {
+			<-p.slots
+			p.pending.Done()
+		}()
+		send()
+	}()
+}
+
+// wait returns once every batch was sent
+func (p *txPipeline) wait() {
+	p.pending.Wait()
+}
+
+// statusReport describes every faucet account of the chain and the balances it holds
+func (cf ChainFaucet) statusReport() string {
+	accounts, err := cf.chain.AccountBalances(context.Background())
 	if err != nil {
-		reportError(sr.session, sr.msg, err)
-		return
+		log.Error(err)
+		return fmt.Sprintf("**%s**: failed to query balances", cf.chain.Prefix)
+	}
+
+	var lines []string
+	for _, account := range accounts {
+		lines = append(lines, fmt.Sprintf("`%s`\nCurrent balance: %s", account.Address, cf.formatCoins(account.Coins)))
+	}
+	return fmt.Sprintf("**%s** %s", cf.chain.Prefix, strings.Join(lines, "\n"))
+}
+
+func (cf ChainFaucet) formatCoins(coins types.Coins) string {
+	var amounts []string
+	for _, coin := range coins {
+		amounts = append(amounts, fmt.Sprintf("`%s`", cf.chain.FormatCoin(coin)))
+	}
+	if len(amounts) == 0 {
+		amounts = append(amounts, "`empty`")
+	}
+	return strings.Join(amounts, ", ")
+}
+
+const (
+	// batches per faucet account broadcast and waiting to be committed at once.
+	// The chain client signs them with locally tracked sequences.
+	maxInFlightTxs = 4
+	// times a batch is resent after a transient failure
+	maxTransientRetries = 3
+	// first wait before resending, doubled on every retry
+	retryBackoff = 2 * time.Second
+	// txs sent for one batch, including retries and bisected halves
+	maxBatchAttempts = 32
+)
+
+func (cf ChainFaucet) processRequests(rs []FaucetReq, p *txPipeline) {
+	rs = cf.dedupe(rs)
+	for _, batch := range cf.splitByGas(rs) {
+		batch := batch
+		p.run(func() {
+			budget := maxBatchAttempts
+			cf.dispense(batch, &budget)
+		})
+	}
+}
+
+// dedupe drops the requests of a batch for a recipient or by a requester already in the batch,
+// so nobody is paid twice, and tells their requesters they were merged.
+func (cf ChainFaucet) dedupe(rs []FaucetReq) []FaucetReq {
+	recipients := map[string]FaucetReq{}
+	requesters := map[string]FaucetReq{}
+	unique := make([]FaucetReq, 0, len(rs))
+	for _, r := range rs {
+		kept, ok := requesters[r.Requester.ID]
+		if !ok {
+			kept, ok = recipients[string(r.Recipient)]
+		}
+		if !ok {
+			requesters[r.Requester.ID] = r
+			recipients[string(r.Recipient)] = r
+			unique = append(unique, r)
+			continue
+		}
+
+		log.Infof("%s worker merged request of %s for %s into the one of %s for %s", cf.chain.Prefix, r.Requester.ID, r.receipt.Recipient, kept.Requester.ID, kept.receipt.Recipient)
+		// the receipt of the same requester is the one of the kept request, which settles it
+		if r.Requester.ID != kept.Requester.ID {
+			if err := cf.db.FailFundingSlot(context.Background(), r.receipt, ""); err != nil {
+				log.Error(err)
+			}
+		}
+		r.Responder.Merged(r, kept.receipt.Recipient)
+		cf.complete(r)
+	}
+	return unique
+}
+
+// splitByGas splits rs into batches whose simulated gas fits the chain's gas limit.
+// When the gas can't be estimated rs is sent as is, and dispense deals with the failure.
+func (cf ChainFaucet) splitByGas(rs []FaucetReq) [][]FaucetReq {
+	if len(rs) < 2 {
+		return [][]FaucetReq{rs}
+	}
+	estimator := cf.estimator
+	if estimator == nil {
+		estimator = cf.chain
 	}
-	faucetAddrStr, err := c.EncodeBech32AccAddr(faucetRawAddr)
+	limit, err := estimator.GasLimit(context.Background())
 	if err != nil {
-		reportError(sr.session, sr.msg, err)
-		return
+		log.Error(err)
+		return [][]FaucetReq{rs}
 	}
-	url := fmt.Sprintf("%s/cosmos/bank/v1beta1/balances/%s", subenv.Env("LCD_ADDRESS", "http://127.0.0.1:1317"), faucetAddrStr)
-	client := http.Client{Timeout: time.Second * 2}
-	req, err := http.NewRequest("GET", url, nil)
+	if limit == 0 {
+		return [][]FaucetReq{rs}
+	}
+	toAddrs, coins, _ := batchOutputs(rs)
+	gas, err := estimator.EstimateMultiSend(toAddrs, coins)
 	if err != nil {
-		reportError(sr.session, sr.msg, fmt.Errorf("failed to create request"))
-		log.Error(err.Error())
-		return
+		log.Error(err)
+		return [][]FaucetReq{rs}
+	}
+	if gas <= limit {
+		return [][]FaucetReq{rs}
 	}
-	req.Header.Set("User-Agent", "andromeda-fonzie-ayeee")
 
-	res, getErr := client.Do(req)
-	if getErr != nil {
-		reportError(sr.session, sr.msg, fmt.Errorf("failed to query LCD"))
-		log.Error(err.Error())
-		return
+	// gas grows about linearly with the recipients, the estimate of every part is checked again
+	size := int(uint64(len(rs)) * limit / gas)
+	if size < 1 {
+		size = 1
+	} else if size >= len(rs) {
+		size = len(rs) - 1
+	}
+	log.Infof("%s batch of %d requests needs %d gas, over the %d limit, splitting by %d", cf.chain.Prefix, len(rs), gas, limit, size)
+	var batches [][]FaucetReq
+	for start := 0; start < len(rs); start += size {
+		end := start + size
+		if end > len(rs) {
+			end = len(rs)
+		}
+		batches = append(batches, cf.splitByGas(rs[start:end])...)
+	}
+	return batches
+}
+
+// dispense sends rs in one multi-send. Transient failures are resent as is, failures of the faucet
+// account fail the whole batch, and other failures are bisected so a bad request, such as a blocked
+// recipient, doesn't fail the rest of the batch.
+// budget caps the txs sent for the whole batch; once spent, failed requests aren't retried.
+func (cf ChainFaucet) dispense(rs []FaucetReq, budget *int) {
+	var err error
+	var tx chain.TxResult
+	for retry := 0; ; retry++ {
+		if cf.abort.Err() != nil {
+			err = errShuttingDown
+			break
+		}
+		*budget--
+		err, tx = cf.multiSend(rs)
+		if err == nil || !chain.IsTransient(err) || retry >= maxTransientRetries || *budget <= 0 {
+			break
+		}
+		wait := retryBackoff << retry
+		log.Warnf("%s multi-send of %d requests failed, retrying in %s: %v", cf.chain.Prefix, len(rs), wait, err)
+		select {
+		case <-cf.abort.Done():
+		case <-time.After(wait):
+		}
 	}
 
-	if res.Body != nil {
-		defer res.Body.Close()
+	// an unconfirmed tx may still be committed, so it must neither be retried nor split, and errors
+	// of the faucet account would fail every half. Only recipients are singled out.
+	split := err != nil && !errors.Is(err, chain.ErrUnconfirmed) && !chain.IsTransient(err) && !chain.FailsWholeBatch(err)
+	if split && len(rs) > 1 && *budget > 0 && cf.abort.Err() == nil {
+		log.Warnf("%s multi-send of %d requests failed, splitting the batch: %v", cf.chain.Prefix, len(rs), err)
+		half := len(rs) / 2
+		cf.dispense(rs[:half], budget)
+		cf.dispense(rs[half:], budget)
+		return
 	}
+	cf.settle(rs, err, tx)
+}
 
-	body, readErr := ioutil.ReadAll(res.Body)
-	if readErr != nil {
-		reportError(sr.session, sr.msg, fmt.Errorf("failed to read LCD response"))
-		log.Error(err.Error())
+// settle records the outcome of the tx sending rs, tells their requesters and removes them from the queue
+func (cf ChainFaucet) settle(rs []FaucetReq, err error, tx chain.TxResult) {
+	if err == nil {
+		for _, r := range rs {
+			if err := cf.db.FinalizeFundingSlot(context.Background(), r.receipt, tx.Hash); err != nil {
+				log.Error(err)
+			}
+			r.Responder.Funded(r, tx)
+			cf.complete(r)
+		}
+		return
+	}
+	if errors.Is(err, chain.ErrUnconfirmed) {
+		for _, r := range rs {
+			if err := cf.db.UnconfirmFundingSlot(context.Background(), r.receipt, tx.Hash); err != nil {
+				log.Error(err)
+			}
+			r.Responder.Unconfirmed(r, tx.Hash)
+			cf.complete(r)
+		}
 		return
 	}
 
-	response := BalanceResponse{}
-	jsonErr := json.Unmarshal(body, &response)
-	if jsonErr != nil {
-		reportError(sr.session, sr.msg, fmt.Errorf("failed to parse LCD response"))
-		log.Error(err.Error())
+	for _, r := range rs {
+		// nothing was sent, so the requester shouldn't be held to the cooldown
+		if err := cf.db.FailFundingSlot(context.Background(), r.receipt, tx.Hash); err != nil {
+			log.Error(err)
+		}
+		r.Responder.Failed(r, err)
+		cf.complete(r)
+	}
+}
+
+// complete removes a processed request from the durable queue
+func (cf ChainFaucet) complete(r FaucetReq) {
+	if r.queueID == "" {
 		return
 	}
-	removedReaction(sr.session, sr.msg, "⚙️")
-	sendReaction(sr.session, sr.msg, "✅")
-	_, err = sr.session.ChannelMessageSendReply(sr.msg.ChannelID,
-		fmt.Sprintf("Faucet status:\nCurrent balance: `%s ANDR`\nSend DMs: `%v`", response.getBalance(), subenv.EnvB("SEND_DM", false)),
-		sr.msg.Reference())
-	if err != nil {
+	if err := cf.db.CompleteQueuedRequest(context.Background(), r.queueID); err != nil {
 		log.Error(err)
 	}
 }
 
-func (cf ChainFaucet) processRequests(rs []FaucetReq) {
+func (cf ChainFaucet) multiSend(rs []FaucetReq) (error, chain.TxResult) {
+	// once broadcast, a replay of the requests must not send them again
+	broadcast := func(txHash string) {
+		for _, r := range rs {
+			if err := cf.db.BroadcastFundingSlot(context.Background(), r.receipt, txHash); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	if cf.send != nil {
+		return cf.send(rs, broadcast)
+	}
+	toAddrs, coins, fees := batchOutputs(rs)
+	return cf.chain.MultiSend(toAddrs, coins, fees, broadcast)
+}
+
+// resolve settles requests whose tx was broadcast by a process which stopped waiting for it
+func (cf ChainFaucet) resolve(rs []FaucetReq, txHash string) {
+	var err error
+	var tx chain.TxResult
+	if cf.findTx != nil {
+		err, tx = cf.findTx(txHash)
+	} else {
+		err, tx = cf.chain.FindTx(context.Background(), txHash)
+	}
+	cf.settle(rs, err, tx)
+}
+
+// batchOutputs returns the recipients and amounts of a multi-send for rs, and the fees they pay together
+func batchOutputs(rs []FaucetReq) ([]types.AccAddress, []types.Coins, types.Coins) {
 	var toAddrss = make([]types.AccAddress, 0, len(rs))
 	var coins = make([]types.Coins, 0, len(rs))
 	var fees = make(types.Coins, 0, len(rs))
@@ -170,26 +398,40 @@ func (cf ChainFaucet) processRequests(rs []FaucetReq) {
 		coins = append(coins, r.Coins)
 		fees = fees.Add(r.Fees...)
 	}
-	err, txh := cf.chain.MultiSend(toAddrss, coins, fees)
-	if err != nil {
-		for _, r := range rs {
-			reportError(r.session, r.msg, err)
-		}
-	} else {
-		for _, r := range rs {
-			// Everything worked, so-- respond successfully to Discord requester
-			sendReaction(r.session, r.msg, "✅")
-			removedReaction(r.session, r.msg, "⚙️")
-			_, err = r.session.ChannelMessageSendReply(r.msg.ChannelID,
-				fmt.Sprintf("Hey <@%s>, faucet tapped, just for you!\nTransaction hash\n%s/%s", r.msg.Author.ID, subenv.Env("FINDER_URL", "https://ping.wildsage.io/andromeda/tx"), txh),
-				r.msg.Reference())
-			if err != nil {
-				log.Error(err)
-			}
-			if subenv.EnvB("SEND_DM", false) {
-				sendMessage(r.session, r.msg, fmt.Sprintf("Dispensed 💸 `%s` to `%s`\n%s", r.Coins, r.Recipient, fmt.Sprintf("Transaction hash\n%s/%s", subenv.Env("FINDER_URL", "https://ping.wildsage.io/andromeda/tx"), txh)))
-			}
+	return toAddrss, coins, fees
+}
 
-		}
-	}
+// FaucetResult is the outcome of a request, as delivered by a FaucetResultChan
+type FaucetResult struct {
+	TxHash string
+	// Height is 0 when the tx was broadcast but not confirmed
+	Height int64
+	// Merged is the recipient paid instead, when the request was merged into another one
+	Merged string
+	Err    error
+}
+
+// FaucetResultChan is a Responder for frontends which wait for the outcome, such as the HTTP API.
+// It must be buffered so the worker never blocks on it.
+type FaucetResultChan chan FaucetResult
+
+func (ch FaucetResultChan) Funded(r FaucetReq, tx chain.TxResult) {
+	ch <- FaucetResult{TxHash: tx.Hash, Height: tx.Height}
+}
+
+func (ch FaucetResultChan) Unconfirmed(r FaucetReq, txHash string) {
+	ch <- FaucetResult{TxHash: txHash}
+}
+
+func (ch FaucetResultChan) Merged(r FaucetReq, recipient string) {
+	ch <- FaucetResult{Merged: recipient}
+}
+
+func (ch FaucetResultChan) Failed(r FaucetReq, err error) {
+	ch <- FaucetResult{Err: err}
+}
+
+// ReplyRef can't point back at the HTTP client, which is gone after a restart
+func (ch FaucetResultChan) ReplyRef() db.ReplyRef {
+	return db.ReplyRef{Frontend: "http"}
 }
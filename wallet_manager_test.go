@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+
+	"github.com/umee-network/fonzie/chain"
+	"github.com/umee-network/fonzie/db"
+)
+
+// outcomes records what every requester was told
+type outcomes struct {
+	mu   sync.Mutex
+	told map[string]string
+}
+
+func (o *outcomes) set(r FaucetReq, outcome string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.told[r.Requester.ID] = outcome
+}
+
+func (o *outcomes) get(id string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.told[id]
+}
+
+func (o *outcomes) Funded(r FaucetReq, tx chain.TxResult) {
+	o.set(r, "funded "+tx.Hash)
+}
+
+func (o *outcomes) Unconfirmed(r FaucetReq, txHash string) {
+	o.set(r, "unconfirmed "+txHash)
+}
+
+func (o *outcomes) Merged(r FaucetReq, recipient string) {
+	o.set(r, "merged into "+recipient)
+}
+
+func (o *outcomes) Failed(r FaucetReq, err error) {
+	o.set(r, "failed")
+}
+
+func (o *outcomes) ReplyRef() db.ReplyRef {
+	return db.ReplyRef{}
+}
+
+// testFaucet returns a faucet whose multi-sends go to send
+func testFaucet(send func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult)) (ChainFaucet, *outcomes) {
+	ctx := context.Background()
+	return ChainFaucet{
+		chain: &chain.Chain{Prefix: "umee"},
+		db:    db.NewDb(ctx, db.NewMemoryReceiptStore()),
+		abort: ctx,
+		send:  send,
+	}, &outcomes{told: map[string]string{}}
+}
+
+// testReq returns a request of requester for recipient, with its receipt pending in cf's store
+func testReq(t *testing.T, cf ChainFaucet, o *outcomes, requester string, recipient string) FaucetReq {
+	receipt := db.FundingReceipt{
+		ChainPrefix: "umee",
+		Username:    requester,
+		Recipient:   recipient,
+		Status:      db.ReceiptPending,
+	}
+	if err := cf.db.SaveFundingReceipt(context.Background(), receipt); err != nil {
+		t.Fatal(err)
+	}
+	return FaucetReq{
+		Recipient: types.AccAddress(recipient),
+		Requester: Requester{ID: requester},
+		Responder: o,
+		receipt:   receipt,
+	}
+}
+
+func receiptStatus(t *testing.T, cf ChainFaucet, requester string) string {
+	receipt, err := cf.db.GetFundingReceiptByUsernameAndChainPrefix(context.Background(), requester, "umee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receipt.Status
+}
+
+func TestDispenseBisectsFailures(t *testing.T) {
+	var sent []int
+	cf, o := testFaucet(func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult) {
+		sent = append(sent, len(rs))
+		for _, r := range rs {
+			if r.Requester.ID == "bad" {
+				return sdkerrors.Wrap(sdkerrors.ErrUnauthorized, "blocked address"), chain.TxResult{}
+			}
+		}
+		return nil, chain.TxResult{Hash: fmt.Sprintf("TX%d", len(sent)), Height: 1}
+	})
+	rs := []FaucetReq{
+		testReq(t, cf, o, "alice", "umee1a"),
+		testReq(t, cf, o, "bad", "umee1b"),
+		testReq(t, cf, o, "carol", "umee1c"),
+		testReq(t, cf, o, "dave", "umee1d"),
+	}
+
+	budget := maxBatchAttempts
+	cf.dispense(rs, &budget)
+	if want := []int{4, 2, 1, 1, 2}; fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("sent batches of %v, want %v", sent, want)
+	}
+	for id, want := range map[string]string{
+		"alice": "funded TX3",
+		"bad":   "failed",
+		"carol": "funded TX5",
+		"dave":  "funded TX5",
+	} {
+		if got := o.get(id); got != want {
+			t.Errorf("%s was told %q, want %q", id, got, want)
+		}
+	}
+	if got := receiptStatus(t, cf, "bad"); got != db.ReceiptFailed {
+		t.Errorf("bad's receipt is %s, want failed", got)
+	}
+	if got := receiptStatus(t, cf, "alice"); got != db.ReceiptConfirmed {
+		t.Errorf("alice's receipt is %s, want confirmed", got)
+	}
+}
+
+func TestDispenseStopsAtBudget(t *testing.T) {
+	sends := 0
+	cf, o := testFaucet(func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult) {
+		sends++
+		return sdkerrors.Wrap(sdkerrors.ErrUnauthorized, "blocked address"), chain.TxResult{}
+	})
+	var rs []FaucetReq
+	for i := 0; i < 8; i++ {
+		rs = append(rs, testReq(t, cf, o, fmt.Sprint("user", i), fmt.Sprint("umee1", i)))
+	}
+
+	budget := 2
+	cf.dispense(rs, &budget)
+	// the whole batch, then each half once without splitting further
+	if sends != 3 {
+		t.Errorf("sent %d txs, want 3", sends)
+	}
+	for _, r := range rs {
+		if got := o.get(r.Requester.ID); got != "failed" {
+			t.Errorf("%s was told %q, want failed", r.Requester.ID, got)
+		}
+	}
+}
+
+func TestDispenseUnconfirmed(t *testing.T) {
+	sends := 0
+	cf, o := testFaucet(func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult) {
+		sends++
+		return fmt.Errorf("%w: tx ABC", chain.ErrUnconfirmed), chain.TxResult{Hash: "ABC"}
+	})
+	rs := []FaucetReq{
+		testReq(t, cf, o, "alice", "umee1a"),
+		testReq(t, cf, o, "bob", "umee1b"),
+	}
+
+	budget := maxBatchAttempts
+	cf.dispense(rs, &budget)
+	// the tx may still be committed, so it is neither resent nor split
+	if sends != 1 {
+		t.Errorf("sent %d txs, want 1", sends)
+	}
+	for _, r := range rs {
+		if got := o.get(r.Requester.ID); got != "unconfirmed ABC" {
+			t.Errorf("%s was told %q", r.Requester.ID, got)
+		}
+		if got := receiptStatus(t, cf, r.Requester.ID); got != db.ReceiptUnconfirmed {
+			t.Errorf("%s's receipt is %s, want unconfirmed", r.Requester.ID, got)
+		}
+	}
+}
+
+func TestDispenseRetriesTransientFailures(t *testing.T) {
+	sends := 0
+	cf, o := testFaucet(func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult) {
+		sends++
+		if sends == 1 {
+			return sdkerrors.ErrMempoolIsFull, chain.TxResult{}
+		}
+		return nil, chain.TxResult{Hash: "ABC", Height: 1}
+	})
+	rs := []FaucetReq{
+		testReq(t, cf, o, "alice", "umee1a"),
+		testReq(t, cf, o, "bob", "umee1b"),
+	}
+
+	budget := maxBatchAttempts
+	cf.dispense(rs, &budget)
+	// the same batch is resent rather than split
+	if sends != 2 {
+		t.Errorf("sent %d txs, want 2", sends)
+	}
+	for _, r := range rs {
+		if got := o.get(r.Requester.ID); got != "funded ABC" {
+			t.Errorf("%s was told %q", r.Requester.ID, got)
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	cf, o := testFaucet(nil)
+	rs := []FaucetReq{
+		testReq(t, cf, o, "alice", "umee1a"),
+		testReq(t, cf, o, "bob", "umee1a"),
+		testReq(t, cf, o, "carol", "umee1c"),
+		testReq(t, cf, o, "carol", "umee1d"),
+	}
+
+	unique := cf.dedupe(rs)
+	if len(unique) != 2 || unique[0].Requester.ID != "alice" || unique[1].Requester.ID != "carol" {
+		t.Fatalf("kept %v, want the requests of alice and carol", unique)
+	}
+	if got := o.get("bob"); got != "merged into umee1a" {
+		t.Errorf("bob was told %q", got)
+	}
+	// carol's second request has the receipt of the first one, which is still sent
+	if got := o.get("carol"); got != "merged into umee1c" {
+		t.Errorf("carol was told %q", got)
+	}
+	if got := receiptStatus(t, cf, "bob"); got != db.ReceiptFailed {
+		t.Errorf("bob's receipt is %s, want it failed to lift the cooldown", got)
+	}
+	for _, requester := range []string{"alice", "carol"} {
+		if got := receiptStatus(t, cf, requester); got != db.ReceiptPending {
+			t.Errorf("%s's receipt is %s, want it pending", requester, got)
+		}
+	}
+}
+
+func TestDispenseFailsWholeBatch(t *testing.T) {
+	sends := 0
+	cf, o := testFaucet(func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult) {
+		sends++
+		return sdkerrors.Wrap(sdkerrors.ErrInsufficientFunds, "1000uumee is smaller than 4000uumee"), chain.TxResult{}
+	})
+	var rs []FaucetReq
+	for i := 0; i < 4; i++ {
+		rs = append(rs, testReq(t, cf, o, fmt.Sprint("user", i), fmt.Sprint("umee1", i)))
+	}
+
+	budget := maxBatchAttempts
+	cf.dispense(rs, &budget)
+	// the faucet account can't pay any half either
+	if sends != 1 {
+		t.Errorf("sent %d txs, want 1", sends)
+	}
+	for _, r := range rs {
+		if got := o.get(r.Requester.ID); got != "failed" {
+			t.Errorf("%s was told %q, want failed", r.Requester.ID, got)
+		}
+		if got := receiptStatus(t, cf, r.Requester.ID); got != db.ReceiptFailed {
+			t.Errorf("%s's receipt is %s, want failed", r.Requester.ID, got)
+		}
+	}
+}
+
+func TestDispenseRecordsBroadcast(t *testing.T) {
+	var cf ChainFaucet
+	var during []string
+	cf, o := testFaucet(func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult) {
+		broadcast("ABC")
+		// a replay while the tx is waiting to be committed must find its hash
+		for _, r := range rs {
+			receipt, err := cf.db.GetFundingReceiptByUsernameAndChainPrefix(context.Background(), r.Requester.ID, "umee")
+			if err != nil {
+				t.Fatal(err)
+			}
+			during = append(during, receipt.Status+" "+receipt.TxHash)
+		}
+		return nil, chain.TxResult{Hash: "ABC", Height: 1}
+	})
+	rs := []FaucetReq{
+		testReq(t, cf, o, "alice", "umee1a"),
+		testReq(t, cf, o, "bob", "umee1b"),
+	}
+
+	budget := maxBatchAttempts
+	cf.dispense(rs, &budget)
+	if want := []string{"broadcast ABC", "broadcast ABC"}; fmt.Sprint(during) != fmt.Sprint(want) {
+		t.Errorf("receipts were %q while waiting, want %q", during, want)
+	}
+	for _, r := range rs {
+		if got := receiptStatus(t, cf, r.Requester.ID); got != db.ReceiptConfirmed {
+			t.Errorf("%s's receipt is %s, want confirmed", r.Requester.ID, got)
+		}
+	}
+}
+
+func TestResolveBroadcast(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		height int64
+		told   string
+		status string
+	}{
+		{"committed", nil, 7, "funded ABC", db.ReceiptConfirmed},
+		{"failed in DeliverTx", sdkerrors.ErrUnauthorized, 7, "failed", db.ReceiptFailed},
+		{"not in a block", chain.ErrUnconfirmed, 0, "unconfirmed ABC", db.ReceiptUnconfirmed},
+	}
+	for _, c := range cases {
+		cf, o := testFaucet(func(rs []FaucetReq, broadcast func(txHash string)) (error, chain.TxResult) {
+			t.Errorf("%s: sent the broadcast request again", c.name)
+			return nil, chain.TxResult{}
+		})
+		var looked []string
+		cf.findTx = func(txHash string) (error, chain.TxResult) {
+			looked = append(looked, txHash)
+			return c.err, chain.TxResult{Hash: txHash, Height: c.height}
+		}
+		r := testReq(t, cf, o, "alice", "umee1a")
+		if err := cf.db.BroadcastFundingSlot(context.Background(), r.receipt, "ABC"); err != nil {
+			t.Fatal(err)
+		}
+
+		cf.resolve([]FaucetReq{r}, "ABC")
+		if len(looked) != 1 || looked[0] != "ABC" {
+			t.Errorf("%s: looked up %q, want ABC", c.name, looked)
+		}
+		if got := o.get("alice"); got != c.told {
+			t.Errorf("%s: told %q, want %q", c.name, got, c.told)
+		}
+		if got := receiptStatus(t, cf, "alice"); got != c.status {
+			t.Errorf("%s: receipt is %s, want %s", c.name, got, c.status)
+		}
+	}
+}
+
+// fakeGas is a gasEstimator whose multi-sends need base gas plus perOutput for every recipient
+type fakeGas struct {
+	limit     uint64
+	limitErr  error
+	base      uint64
+	perOutput uint64
+	// estimateErr fails the estimates of batches of failSize recipients
+	estimateErr error
+	failSize    int
+}
+
+func (g *fakeGas) GasLimit(ctx context.Context) (uint64, error) {
+	return g.limit, g.limitErr
+}
+
+func (g *fakeGas) EstimateMultiSend(toAddr []types.AccAddress, coins []types.Coins) (uint64, error) {
+	if len(toAddr) == g.failSize {
+		return 0, g.estimateErr
+	}
+	return g.base + g.perOutput*uint64(len(toAddr)), nil
+}
+
+func TestSplitByGas(t *testing.T) {
+	cases := []struct {
+		name  string
+		gas   fakeGas
+		sizes []int
+	}{
+		{"fits", fakeGas{limit: 20000, base: 1000, perOutput: 1000}, []int{10}},
+		{"no limit", fakeGas{base: 1000, perOutput: 1000}, []int{10}},
+		// the parts of the first split need more gas per recipient than the whole batch
+		{"over the limit", fakeGas{limit: 5500, base: 1000, perOutput: 1000}, []int{4, 1, 4, 1}},
+		{"exactly at the limit", fakeGas{limit: 6000, base: 1000, perOutput: 1000}, []int{5, 5}},
+		{"each request over the limit", fakeGas{limit: 500, base: 1000, perOutput: 1000}, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{"limit unknown", fakeGas{limitErr: errors.New("node down"), base: 1000, perOutput: 1000}, []int{10}},
+		{"estimate failed", fakeGas{limit: 5500, base: 1000, perOutput: 1000, estimateErr: errors.New("out of gas"), failSize: 10}, []int{10}},
+		// parts which can't be estimated are sent as they are, and dispense deals with them
+		{"estimate of a part failed", fakeGas{limit: 5500, base: 1000, perOutput: 1000, estimateErr: errors.New("out of gas"), failSize: 5}, []int{5, 5}},
+	}
+	for _, c := range cases {
+		cf, o := testFaucet(nil)
+		gas := c.gas
+		cf.estimator = &gas
+		var rs []FaucetReq
+		for i := 0; i < 10; i++ {
+			rs = append(rs, testReq(t, cf, o, fmt.Sprint("user", i), fmt.Sprint("umee1", i)))
+		}
+
+		var sizes []int
+		var sent []FaucetReq
+		for _, batch := range cf.splitByGas(rs) {
+			sizes = append(sizes, len(batch))
+			sent = append(sent, batch...)
+		}
+		if fmt.Sprint(sizes) != fmt.Sprint(c.sizes) {
+			t.Errorf("%s: split into %v, want %v", c.name, sizes, c.sizes)
+		}
+		// every request is sent once, in order
+		for i := range rs {
+			if i >= len(sent) || sent[i].Requester.ID != rs[i].Requester.ID {
+				t.Errorf("%s: sent %v", c.name, sent)
+				break
+			}
+		}
+	}
+}
+
+func TestTxPipelineLimit(t *testing.T) {
+	for _, accounts := range []int{1, 2} {
+		size := maxInFlightTxs * accounts
+		p := newTxPipeline(size)
+		started := make(chan struct{}, 2*size)
+		release := make(chan struct{})
+		queued := make(chan struct{})
+		go func() {
+			defer close(queued)
+			for i := 0; i < 2*size; i++ {
+				p.run(func() {
+					started <- struct{}{}
+					<-release
+				})
+			}
+		}()
+
+		for i := 0; i < size; i++ {
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%d accounts: only %d batches in flight, want %d", accounts, i, size)
+			}
+		}
+		// the next batch waits for a slot
+		select {
+		case <-started:
+			t.Errorf("%d accounts: more than %d batches in flight", accounts, size)
+		case <-time.After(100 * time.Millisecond):
+		}
+		close(release)
+		<-queued
+		p.wait()
+		if len(started) != size {
+			t.Errorf("%d accounts: sent %d batches after the first ones, want %d", accounts, len(started), size)
+		}
+	}
+}